@@ -0,0 +1,231 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// CompiledProgram is a program lowered into a flat list of instructions. The machine runs the instructions in a loop
+// instead of walking the program's nodes, which is faster for a program that's run often.
+//
+// A compiled program behaves exactly like the program it was compiled from.
+type CompiledProgram struct {
+	// The program the instructions were lowered from.
+	Program *ProgramIL
+
+	code []instruction
+}
+
+// The operation an instruction performs
+type opcode int
+
+const (
+	// Starts a stack frame for the node
+	opEnter opcode = iota
+
+	// Ends the current stack frame
+	opLeave
+
+	// Calls the node by walking it
+	opEval
+
+	// Calls a function node whose arguments are all values
+	opCall
+
+	// Calls the ternary node's condition, and jumps to the target when it's false
+	opBranch
+
+	// Jumps to the target
+	opJump
+)
+
+// A single instruction
+type instruction struct {
+	op     opcode
+	node   *NodeIL
+	target int
+}
+
+// Compile lowers the program into instructions for the machine to run with ExecuteCompiled.
+//
+// The program's nodes are validated first, so a program that would fail when it's run returns an IRError instead.
+func (p *ProgramIL) Compile() (*CompiledProgram, error) {
+	if p.Entry == nil {
+		return nil, &IRError{Message: "program has no entry node"}
+	}
+
+	if p.Entry.Kind != NodeIL_ROOT {
+		return nil, &IRError{Node: p.Entry, Message: "entry node must be a ROOT"}
+	}
+
+	if err := validateNode(p.Entry); err != nil {
+		return nil, err
+	}
+
+	return &CompiledProgram{Program: p, code: lower(p.Entry)}, nil
+}
+
+// Lowers the root node's statements into instructions. The root's stack frame is left by the last instruction.
+func lower(root *NodeIL) []instruction {
+	code := []instruction{{op: opEnter, node: root}}
+
+	for _, c := range root.Children {
+		code = lowerNode(code, c)
+	}
+
+	return append(code, instruction{op: opLeave})
+}
+
+// Lowers a node whose value isn't used. Anything that can't be lowered is called by walking it.
+func lowerNode(code []instruction, n *NodeIL) []instruction {
+	switch {
+	case n.Kind == NodeIL_FUNC && n.Chained == nil && valuesOnly(n.Children):
+		return append(code, instruction{op: opCall, node: n})
+	case n.Kind == NodeIL_TERNARY && len(n.Children) == 3:
+		code = append(code, instruction{op: opEnter, node: n})
+
+		branch := len(code)
+		code = append(code, instruction{op: opBranch, node: n})
+		code = lowerNode(code, n.Children[1])
+
+		jump := len(code)
+		code = append(code, instruction{op: opJump})
+
+		code[branch].target = len(code)
+		code = lowerNode(code, n.Children[2])
+		code[jump].target = len(code)
+
+		return append(code, instruction{op: opLeave})
+	default:
+		return append(code, instruction{op: opEval, node: n})
+	}
+}
+
+// Checks that every node is a VALUE with a value.
+func valuesOnly(nodes []*NodeIL) bool {
+	for _, n := range nodes {
+		if n.Kind != NodeIL_VALUE || n.Value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs the instructions. The stack is left the way it was found, even when an instruction fails.
+func (m *machineST) runCode(ctx context.Context, code []instruction) error {
+	base := len(m.stack)
+
+	for pc := 0; pc < len(code); pc++ {
+		var err error
+
+		switch in := code[pc]; in.op {
+		case opEnter:
+			err = m.enter(in.node)
+		case opLeave:
+			m.pop()
+		case opEval:
+			_, err = in.node.call(ctx, m)
+
+			// A return stops running statements. The last instruction leaves the root's stack frame.
+			if err == nil && m.returned {
+				pc = len(code) - 2
+			}
+		case opCall:
+			err = m.callValues(ctx, in.node)
+		case opBranch:
+			var ok bool
+			ok, err = m.branch(ctx, in.node)
+			if err == nil && !ok {
+				pc = in.target - 1
+			}
+		case opJump:
+			pc = in.target - 1
+		default:
+			err = &RuntimeError{
+				Code:    "UnknownInstruction",
+				Message: fmt.Sprintf("no instruction with the opcode %d", in.op),
+			}
+		}
+
+		if err != nil {
+			// The frames are recorded before they're left, the same as a node that's walked.
+			if e, ok := err.(*RuntimeError); ok && e.Frames == nil {
+				e.Frames = m.Frames()
+			}
+			for len(m.stack) > base {
+				m.pop()
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Calls a function node whose arguments are all values. The arguments don't need their own stack frames.
+func (m *machineST) callValues(ctx context.Context, n *NodeIL) error {
+	if err := m.enter(n); err != nil {
+		return err
+	}
+
+	// A function that isn't chained from a group has nothing to spread.
+	m.spread = nil
+
+	fn, err := m.lookup(n.Value.Str)
+	if err != nil {
+		return err
+	}
+	if err := m.allow(fn); err != nil {
+		return err
+	}
+
+	if count := len(n.Children); m.maxArgs > 0 && count > m.maxArgs {
+		m.exceeded(LimitArgs, m.maxArgs)
+		return &RuntimeError{
+			Code:    "TooManyArguments",
+			Message: fmt.Sprintf("Attempting to call '%s' with %d arguments. The maximum is %d", fn.name, count, m.maxArgs),
+			Loc:     uint64(m.ptr),
+		}
+	}
+
+	args := make([]reflect.Value, 0, len(n.Children))
+	for i, c := range n.Children {
+		if fn.lazy[i] {
+			args = append(args, reflect.ValueOf(m.thunk(ctx, c)))
+			continue
+		}
+
+		// Walking the argument's node would move the pointer, and push and pop its frame.
+		m.ptr++
+		if m.onFrame != nil {
+			m.onFrame(len(m.stack)+1, FramePush)
+			m.onFrame(len(m.stack)+1, FramePop)
+		}
+		args = append(args, m.value(c))
+	}
+
+	ret, err := fn.call(ctx, args, m.panicConv)
+	if err != nil {
+		return err
+	}
+
+	if fn.retC != 0 {
+		m.sSet(stackReturnPtr, ret)
+	}
+
+	m.pop()
+
+	return nil
+}
+
+// Calls the ternary node's condition and returns which branch it picks.
+func (m *machineST) branch(ctx context.Context, n *NodeIL) (bool, error) {
+	s, err := n.Children[0].call(ctx, m)
+	if err != nil {
+		return false, err
+	}
+
+	return m.condition(s[stackReturnPtr])
+}
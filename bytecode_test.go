@@ -0,0 +1,143 @@
+package machine_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramCompile(t *testing.T) {
+	// Records every call with its arguments and the stack it was called from.
+	recorder := func(calls *[]string) *Implementation {
+		record := func(ctx context.Context, name string, args ...interface{}) {
+			mac := Mac(ctx)
+			*calls = append(*calls, fmt.Sprintf("%s%v depth=%d frames=%v", name, args, mac.StackDepth(), mac.Frames()))
+		}
+
+		i := &Implementation{}
+		i.EnableTruthiness(true)
+		i.Func("alert", func(ctx context.Context, metric string, operator string, value float64) string {
+			record(ctx, "alert", metric, operator, value)
+			return "alert-id"
+		})
+		i.Func("warn", func(ctx context.Context, metric string, operator string, value float64) string {
+			record(ctx, "warn", metric, operator, value)
+			return "warn-id"
+		})
+		i.Func("recover", func(ctx context.Context, operator string, value float64) {
+			record(ctx, "recover", operator, value, LastReturn(ctx))
+		})
+		i.Func("page", func(ctx context.Context) {
+			record(ctx, "page", LastReturn(ctx))
+		})
+		i.Func("scale-up", func(ctx context.Context, appName string, metric string, operator string, value float64) {
+			record(ctx, "scale-up", appName, metric, operator, value)
+		})
+		i.Func("scale-down", func(ctx context.Context, appName string, metric string, operator string, value float64) {
+			record(ctx, "scale-down", appName, metric, operator, value)
+		})
+		i.Func("slack", func(ctx context.Context, channelName string, resourceID string) {
+			record(ctx, "slack", channelName, resourceID)
+		})
+		i.Func("enable", func(ctx context.Context, kind string, appName string, enabled bool) {
+			record(ctx, "enable", kind, appName, enabled)
+		})
+		i.Func("fail", func(ctx context.Context, reason string) error {
+			record(ctx, "fail", reason)
+			return fmt.Errorf("failed: %s", reason)
+		})
+
+		return i
+	}
+
+	// Records every frame event from the machine's frame hook.
+	frameRecorder := func(events *[]string) func(int, FrameEvent) {
+		return func(depth int, event FrameEvent) {
+			*events = append(*events, fmt.Sprintf("%s %d", event, depth))
+		}
+	}
+
+	// Runs the program by walking it and from its compiled code, and checks both runs have the same results.
+	compare := func(t *testing.T, src string) {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		compiled, err := prog.Compile()
+
+		require.NoError(t, err)
+
+		var walked, ran []string
+		var wFrames, cFrames []string
+
+		wm := NewSync(recorder(&walked))
+		wm.Setenv("app-name", "testing-app")
+		wm.SetFrameHook(frameRecorder(&wFrames))
+		wValue, wErr := wm.ExecuteValue(prog)
+
+		cm := NewSync(recorder(&ran))
+		cm.Setenv("app-name", "testing-app")
+		cm.SetFrameHook(frameRecorder(&cFrames))
+		cValue, cErr := cm.ExecuteCompiled(compiled)
+
+		assert.NotEmpty(t, walked)
+		assert.Equal(t, walked, ran)
+		assert.Equal(t, wFrames, cFrames)
+		assert.Equal(t, wValue, cValue)
+		assert.Equal(t, wErr, cErr)
+		assert.Equal(t, wm.State().StackDepth(), cm.State().StackDepth())
+	}
+
+	t.Run("given the example program", func(t *testing.T) {
+		compare(t, load("example.mac"))
+	})
+
+	t.Run("given calls with only value arguments", func(t *testing.T) {
+		compare(t, "slack(a b);\npage();")
+	})
+
+	t.Run("given ternary statements", func(t *testing.T) {
+		compare(t, "env(app-name) ? page() : slack(a b);\nenv(missing) ? page() : env(app-name) ? slack(c d) : page();")
+	})
+
+	t.Run("given a return", func(t *testing.T) {
+		compare(t, "page();\nreturn warn(cpu GT f0.5);\npage();")
+	})
+
+	t.Run("given a trailing group", func(t *testing.T) {
+		compare(t, "page();\n(alert(cpu GT f1) | warn(cpu GT f2));")
+	})
+
+	t.Run("given a failing function", func(t *testing.T) {
+		compare(t, "page();\nenv(app-name) ? fail(nope) : page();\npage();")
+	})
+
+	t.Run("given a failing condition", func(t *testing.T) {
+		i := &Implementation{}
+		i.Func("page", func() {})
+
+		prog, err := CompileSource("page();\nenv(app-name) ? page() : page();")
+
+		require.NoError(t, err)
+
+		compiled, err := prog.Compile()
+
+		require.NoError(t, err)
+
+		_, wErr := NewSync(i).ExecuteValue(prog)
+		_, cErr := NewSync(i).ExecuteCompiled(compiled)
+
+		require.Error(t, cErr)
+		assert.Equal(t, wErr, cErr)
+	})
+
+	t.Run("given a program without an entry node", func(t *testing.T) {
+		_, err := (&ProgramIL{}).Compile()
+
+		assert.Error(t, err)
+	})
+}
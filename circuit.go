@@ -0,0 +1,92 @@
+package machine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// The failure bookkeeping for a single program.
+type circuit struct {
+	failures int
+	openedAt time.Time
+}
+
+// SetCircuitBreaker stops running a program after it fails threshold times in a row. Until the cooldown has passed
+// executing the program returns a CircuitOpen error without running it.
+//
+// After the cooldown the program is run again. A success closes the circuit, and a failure opens it for another
+// cooldown. A threshold of zero disables the breaker.
+func (m *Machine) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cbThreshold = threshold
+	m.cbCooldown = cooldown
+	m.circuits = make(map[string]*circuit, 0)
+}
+
+// Returns a CircuitOpen error if the program has failed too many times to be run.
+func (m *Machine) circuitCheck(p *ProgramIL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cbThreshold <= 0 {
+		return nil
+	}
+
+	c, ok := m.circuits[string(p.Id)]
+	if !ok || c.failures < m.cbThreshold {
+		return nil
+	}
+
+	if wait := m.cbCooldown - time.Since(c.openedAt); wait > 0 {
+		return &RuntimeError{
+			Code:    "CircuitOpen",
+			Message: fmt.Sprintf("program %s failed %d times in a row, retry in %s", programName(p), c.failures, wait.Round(time.Millisecond)),
+		}
+	}
+
+	// The cooldown is over. The next failure opens the circuit again.
+	c.failures = m.cbThreshold - 1
+
+	return nil
+}
+
+// Records the result of running the program.
+func (m *Machine) circuitRecord(p *ProgramIL, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cbThreshold <= 0 {
+		return
+	}
+
+	id := string(p.Id)
+
+	if err == nil {
+		delete(m.circuits, id)
+		return
+	}
+
+	c, ok := m.circuits[id]
+	if !ok {
+		c = &circuit{}
+		m.circuits[id] = c
+	}
+
+	c.failures++
+	if c.failures >= m.cbThreshold {
+		c.openedAt = time.Now()
+	}
+}
+
+// Returns a readable name for the program from its ID.
+func programName(p *ProgramIL) string {
+	id, err := ksuid.FromBytes(p.Id)
+	if err != nil {
+		return fmt.Sprintf("%x", p.Id)
+	}
+	return id.String()
+}
@@ -0,0 +1,128 @@
+package machine
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// Converts a value into the type of a function parameter.
+//
+// Values that can already be assigned to the parameter are returned untouched.
+//
+//	float64 -> time.Duration   the number of seconds
+//	string  -> time.Duration   parsed with time.ParseDuration
+//	string  -> time.Time       parsed as RFC3339
+//	float64 -> int, uint, ...  only whole numbers that fit in the type
+//	float64 -> float32, ...    a named float type, or a number that fits in a float32
+//	string  -> named string    like `type Metric string`
+func coerce(v reflect.Value, to reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		return v, nil
+	}
+
+	if v.Kind() == reflect.Interface && !v.IsNil() && !v.Type().AssignableTo(to) {
+		v = v.Elem()
+	}
+
+	if v.Type().AssignableTo(to) {
+		return v, nil
+	}
+
+	switch to {
+	case durationType:
+		switch v.Kind() {
+		case reflect.Float64:
+			return reflect.ValueOf(time.Duration(v.Float() * float64(time.Second))), nil
+		case reflect.String:
+			d, err := time.ParseDuration(v.String())
+			if err != nil {
+				return v, coerceErr(v, to, err)
+			}
+			return reflect.ValueOf(d), nil
+		}
+	case timeType:
+		if v.Kind() == reflect.String {
+			t, err := time.Parse(time.RFC3339, v.String())
+			if err != nil {
+				return v, coerceErr(v, to, err)
+			}
+			return reflect.ValueOf(t), nil
+		}
+	}
+
+	if v.Kind() == reflect.Float64 {
+		switch to.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return coerceInt(v, to)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return coerceUint(v, to)
+		case reflect.Float32, reflect.Float64:
+			out := reflect.New(to).Elem()
+			if out.OverflowFloat(v.Float()) {
+				return v, coerceErr(v, to, fmt.Errorf("overflows %s", to.String()))
+			}
+			out.SetFloat(v.Float())
+			return out, nil
+		}
+	}
+
+	// Other named types are converted from a value of the same kind.
+	if v.Kind() == reflect.String && to.Kind() == reflect.String && v.Type().ConvertibleTo(to) {
+		return v.Convert(to), nil
+	}
+
+	return v, nil
+}
+
+func coerceInt(v reflect.Value, to reflect.Type) (reflect.Value, error) {
+	f := v.Float()
+	if math.Trunc(f) != f {
+		return v, coerceErr(v, to, fmt.Errorf("not a whole number"))
+	}
+
+	out := reflect.New(to).Elem()
+	if f < math.MinInt64 || f >= math.MaxInt64 || out.OverflowInt(int64(f)) {
+		return v, coerceErr(v, to, fmt.Errorf("overflows %s", to.String()))
+	}
+
+	out.SetInt(int64(f))
+
+	return out, nil
+}
+
+func coerceUint(v reflect.Value, to reflect.Type) (reflect.Value, error) {
+	f := v.Float()
+	if math.Trunc(f) != f {
+		return v, coerceErr(v, to, fmt.Errorf("not a whole number"))
+	}
+
+	out := reflect.New(to).Elem()
+	if f < 0 || f >= math.MaxUint64 || out.OverflowUint(uint64(f)) {
+		return v, coerceErr(v, to, fmt.Errorf("overflows %s", to.String()))
+	}
+
+	out.SetUint(uint64(f))
+
+	return out, nil
+}
+
+func coerceErr(v reflect.Value, to reflect.Type, err error) error {
+	from := fmt.Sprintf("%v", v.Interface())
+	if v.Kind() == reflect.Float64 {
+		from = strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+
+	return &RuntimeError{
+		Code:    "ArgumentTypeError",
+		Message: fmt.Sprintf("unable to convert %s to %s: %v", from, to.String(), err),
+	}
+}
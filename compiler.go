@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	proto "github.com/golang/protobuf/proto"
 	"github.com/maddiesch/failable"
@@ -18,12 +21,142 @@ type compiler struct {
 	Tokens    []*TokenIL
 	Ast       *NodeIL
 	FuncCalls map[string]uint64
+	Options   CompileOptions
+	Stats     CompileStats
+}
+
+// CompileStats contains timing and size information about a compile.
+type CompileStats struct {
+	TokenizeDuration time.Duration
+	ParseDuration    time.Duration
+	TokenCount       int
+	NodeCount        int
+}
+
+// CompileOptions changes how the compiler builds a program.
+type CompileOptions struct {
+	// Optimize folds calls to pure stdlib functions with constant arguments into a single value.
+	Optimize bool
+
+	// MaxDepth is the deepest the parser will nest calls, groups, and chains before failing. Zero uses the default.
+	MaxDepth int
+
+	// Terminator is the rune that ends a statement. Zero uses `;`.
+	//
+	// When it's `\n` every line that isn't empty is a statement, and there are no comments.
+	Terminator rune
+
+	// MaxSourceBytes is the longest source the compiler will read. Zero is unlimited.
+	MaxSourceBytes int
+
+	// MaxTokens is the most tokens the compiler will read from the source. Zero is unlimited.
+	MaxTokens int
+}
+
+// The default statement terminator.
+const defaultTerminator = ';'
+
+func (c *compiler) terminator() rune {
+	if c.Options.Terminator != 0 {
+		return c.Options.Terminator
+	}
+	return defaultTerminator
+}
+
+// The default maximum nesting depth of the parser.
+const defaultMaxParseDepth = maxStackLevel
+
+func (c *compiler) maxDepth() int {
+	if c.Options.MaxDepth > 0 {
+		return c.Options.MaxDepth
+	}
+	return defaultMaxParseDepth
 }
 
 // CompileSource takes source code and turns it into a machine program.
 func CompileSource(src string) (*ProgramIL, error) {
-	ctx := context.Background()
+	return CompileSourceWithOptions(src, CompileOptions{})
+}
+
+// CompileSourceWithOptions takes source code and turns it into a machine program using the passed options.
+func CompileSourceWithOptions(src string, opts CompileOptions) (*ProgramIL, error) {
+	p, _, err := compile(context.Background(), src, opts)
+
+	return p, err
+}
+
+// CompileContext takes source code and turns it into a machine program. The compile stops with the context's error if
+// the context is canceled or times out first.
+func CompileContext(ctx context.Context, src string) (*ProgramIL, error) {
+	p, _, err := compile(ctx, src, CompileOptions{})
+
+	return p, err
+}
+
+// CompileSourceWithStats takes source code and turns it into a machine program, returning how long each compile
+// phase took.
+func CompileSourceWithStats(src string, opts CompileOptions) (*ProgramIL, *CompileStats, error) {
+	p, comp, err := compile(context.Background(), src, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p, &comp.Stats, nil
+}
+
+// Parse takes source code and returns the program's entry node, without building a machine program.
+func Parse(src string) (*NodeIL, error) {
+	comp, err := parse(context.Background(), src, CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return comp.Ast, nil
+}
+
+// TokensString tokenizes the source and renders each token's kind, value, line, and column as a table.
+func TokensString(src string) (string, error) {
+	comp := &compiler{
+		Source: src,
+		Tokens: []*TokenIL{},
+	}
+
+	err := failable.DoWithContext(context.Background(), func(ctx context.Context, fail failable.FailFunc) {
+		tokenize(ctx, comp, fail)
+	})
+	if err != nil {
+		return "", err
+	}
 
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tVALUE\tLINE\tCOLUMN")
+	for _, t := range comp.Tokens {
+		fmt.Fprintf(w, "%s\t%q\t%d\t%d\n", t.Kind.String(), t.Value, t.Line, t.Column)
+	}
+	w.Flush()
+
+	return b.String(), nil
+}
+
+func compile(ctx context.Context, src string, opts CompileOptions) (*ProgramIL, *compiler, error) {
+	comp, err := parse(ctx, src, opts)
+	if err != nil {
+		return nil, comp, err
+	}
+
+	return &ProgramIL{
+		Id:         ksuid.New().Bytes(),
+		Source:     comp.GenerateSource(),
+		Entry:      comp.Ast,
+		FuncCalls:  comp.FuncCalls,
+		SourceHash: comp.Hash,
+	}, comp, nil
+}
+
+// Tokenizes and parses the source. The context is checked for each line and token, so a canceled context stops it.
+func parse(ctx context.Context, src string, opts CompileOptions) (*compiler, error) {
 	hash := sha256.Sum256([]byte(src))
 
 	comp := &compiler{
@@ -31,28 +164,128 @@ func CompileSource(src string) (*ProgramIL, error) {
 		Hash:      hash[:],
 		Tokens:    []*TokenIL{},
 		FuncCalls: map[string]uint64{},
+		Options:   opts,
 	}
 
+	start := time.Now()
+
 	err := failable.DoWithContext(ctx, func(ctx context.Context, fail failable.FailFunc) {
 		tokenize(ctx, comp, fail)
 	})
 	if err != nil {
-		return nil, err
+		return comp, err
 	}
 
+	comp.Stats.TokenizeDuration = time.Since(start)
+	comp.Stats.TokenCount = len(comp.Tokens)
+
+	start = time.Now()
+
 	err = failable.DoWithContext(ctx, func(ctx context.Context, fail failable.FailFunc) {
 		parser(ctx, comp, fail)
 	})
 	if err != nil {
+		return comp, err
+	}
+
+	// Guards against a parser bug building a tree that would never stop running.
+	if n := findCycle(comp.Ast); n != nil {
+		token := &TokenIL{}
+		if len(comp.Tokens) > 0 {
+			token = comp.Tokens[len(comp.Tokens)-1]
+		}
+		return comp, &SyntaxError{Token: token, Node: n, Message: "The program's nodes contain a cycle."}
+	}
+
+	if comp.Options.Optimize {
+		comp.Ast = comp.fold(comp.Ast)
+	}
+
+	comp.Stats.ParseDuration = time.Since(start)
+	comp.Stats.NodeCount = countNodes(comp.Ast)
+
+	return comp, nil
+}
+
+// The line that separates programs in a bundle.
+const bundleDelimiter = "---"
+
+// CompileBundle compiles each program in the source into its own machine program.
+//
+// Programs are separated by a line containing only `---`. Empty programs are skipped.
+func CompileBundle(src string) ([]*ProgramIL, error) {
+	progs := []*ProgramIL{}
+
+	segment := 1
+	lines := []string{}
+
+	flush := func() error {
+		defer func() {
+			segment++
+			lines = lines[:0]
+		}()
+
+		body := strings.Join(lines, "\n")
+		if strings.TrimSpace(body) == "" {
+			return nil
+		}
+
+		p, err := CompileSource(body)
+		if err != nil {
+			return &BundleError{Segment: segment, Err: err}
+		}
+
+		progs = append(progs, p)
+
+		return nil
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) == bundleDelimiter {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := flush(); err != nil {
 		return nil, err
 	}
 
-	return &ProgramIL{
-		Id:        ksuid.New().Bytes(),
-		Source:    comp.GenerateSource(),
-		Entry:     comp.Ast,
-		FuncCalls: comp.FuncCalls,
-	}, nil
+	return progs, nil
+}
+
+// BundleError is returned when a program in a bundle fails to compile. The wrapped error's lines are relative to the
+// start of the program.
+type BundleError struct {
+	Segment int
+	Err     error
+}
+
+func (e *BundleError) Error() string {
+	return fmt.Sprintf("Bundle Error (Program %d): %s", e.Segment, e.Err.Error())
+}
+
+// Unwrap returns the compile error.
+func (e *BundleError) Unwrap() error {
+	return e.Err
+}
+
+// SourceEqual compiles both sources and returns if they create the same program.
+func SourceEqual(a, b string) (bool, error) {
+	pa, err := CompileSource(a)
+	if err != nil {
+		return false, err
+	}
+
+	pb, err := CompileSource(b)
+	if err != nil {
+		return false, err
+	}
+
+	return NodeCompare(pa.Entry, pb.Entry), nil
 }
 
 // GenerateSource returns source code generated from the tokens.
@@ -63,11 +296,15 @@ func (c *compiler) GenerateSource() string {
 		switch token.Kind {
 		case TokenIL_NONE:
 			panic("lol... this should never happen")
-		case TokenIL_VALUE:
-			builder.WriteString(token.Value)
+		case TokenIL_VALUE, TokenIL_STRING:
+			if token.Kind == TokenIL_STRING {
+				builder.WriteString(quote(token.Value))
+			} else {
+				builder.WriteString(token.Value)
+			}
 			if len(c.Tokens)-1 > i {
 				switch c.Tokens[i+1].Kind {
-				case TokenIL_VALUE, TokenIL_VAR:
+				case TokenIL_VALUE, TokenIL_VAR, TokenIL_STRING:
 					builder.WriteRune(' ')
 				default:
 					// Do nothing
@@ -79,14 +316,16 @@ func (c *compiler) GenerateSource() string {
 			builder.WriteRune(')')
 			if len(c.Tokens)-1 > i {
 				switch c.Tokens[i+1].Kind {
-				case TokenIL_VALUE, TokenIL_VAR:
+				case TokenIL_VALUE, TokenIL_VAR, TokenIL_STRING:
 					builder.WriteRune(' ')
 				default:
 					// Do nothing
 				}
 			}
 		case TokenIL_END:
-			builder.WriteRune(';')
+			if term := c.terminator(); term != '\n' {
+				builder.WriteRune(term)
+			}
 			builder.WriteRune('\n')
 		case TokenIL_DOT:
 			builder.WriteRune('.')
@@ -96,6 +335,19 @@ func (c *compiler) GenerateSource() string {
 			builder.WriteString(" = ")
 		case TokenIL_VAR:
 			builder.WriteRune('$')
+		case TokenIL_QUESTION:
+			builder.WriteString(" ? ")
+		case TokenIL_COLON:
+			builder.WriteString(" : ")
+		case TokenIL_PLUS:
+			builder.WriteString(" + ")
+		case TokenIL_LBRACE:
+			builder.WriteString(" {\n")
+		case TokenIL_RBRACE:
+			builder.WriteRune('}')
+			if len(c.Tokens)-1 > i && c.Tokens[i+1].Kind != TokenIL_END {
+				builder.WriteRune('\n')
+			}
 		default:
 			panic(fmt.Sprintf("Hey... dummy... don't forget about token: %+v", token))
 		}
@@ -104,8 +356,18 @@ func (c *compiler) GenerateSource() string {
 	return builder.String()
 }
 
+// Wraps the string in quotes, escaping the characters the tokenizer treats as escapes.
+func quote(str string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(str) + `"`
+}
+
+// The longest line of source the compiler will read.
+const maxLineLength = 16 * 1024 * 1024
+
 func (c *compiler) scanner() *bufio.Scanner {
-	return bufio.NewScanner(strings.NewReader(c.Source))
+	s := bufio.NewScanner(strings.NewReader(c.Source))
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineLength)
+	return s
 }
 
 // IR returns the Intermediate Language Representation of the program
@@ -113,7 +375,173 @@ func (p *ProgramIL) IR() ([]byte, error) {
 	return proto.Marshal(p)
 }
 
+// Hash returns the SHA-256 hash of the source the program was compiled from. Programs compiled from the same source
+// have the same hash.
+func (p *ProgramIL) Hash() []byte {
+	return p.SourceHash
+}
+
+// IRMinimal returns the Intermediate Language Representation of the program without the source or the node IDs.
+//
+// It's smaller than IR, for programs that are only going to be run. The program itself isn't changed.
+func (p *ProgramIL) IRMinimal() ([]byte, error) {
+	min := proto.Clone(p).(*ProgramIL)
+	min.Source = ""
+
+	Walk(min.Entry, func(n *NodeIL) bool {
+		n.Id = nil
+		return true
+	})
+
+	return proto.Marshal(min)
+}
+
 // LoadIR re-creates the program from IR
+//
+// The program's nodes are validated, so IR that would fail when it's run returns an IRError instead.
 func (p *ProgramIL) LoadIR(ir []byte) error {
-	return proto.Unmarshal(ir, p)
+	if err := proto.Unmarshal(ir, p); err != nil {
+		return err
+	}
+
+	if p.Entry == nil {
+		return &IRError{Message: "program has no entry node"}
+	}
+
+	if p.Entry.Kind != NodeIL_ROOT {
+		return &IRError{Node: p.Entry, Message: "entry node must be a ROOT"}
+	}
+
+	return validateNode(p.Entry)
+}
+
+// LoadBase64 re-creates the program from base64 encoded IR
+func LoadBase64(str string) (*ProgramIL, error) {
+	ir, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProgramIL{}
+	if err := p.LoadIR(ir); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// MustLoadBase64 re-creates the program from base64 encoded IR, and panics if it can't be loaded.
+//
+// It's used by the code from GoLiteral to embed a program in a Go package.
+func MustLoadBase64(str string) *ProgramIL {
+	p, err := LoadBase64(str)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// GoLiteral returns a Go expression that re-creates the program from its IR.
+//
+//	var program = machine.MustLoadBase64(`...`)
+func (p *ProgramIL) GoLiteral() (string, error) {
+	ir, err := p.IR()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("machine.MustLoadBase64(`%s`)", base64.StdEncoding.EncodeToString(ir)), nil
+}
+
+// IRError is returned when loading IR that doesn't contain a valid program.
+type IRError struct {
+	Message string
+	Node    *NodeIL
+}
+
+func (e *IRError) Error() string {
+	if e.Node == nil {
+		return fmt.Sprintf("IR Error: %s", e.Message)
+	}
+	return fmt.Sprintf("IR Error (<%s>): %s", e.Node.Kind.String(), e.Message)
+}
+
+// Checks that the node, and every node below it, has what it needs to be run.
+func validateNode(root *NodeIL) (err error) {
+	if n := findCycle(root); n != nil {
+		return &IRError{Node: n, Message: "node is part of a cycle"}
+	}
+
+	Walk(root, func(n *NodeIL) bool {
+		if err != nil {
+			return false
+		}
+
+		if _, ok := NodeIL_Kind_name[int32(n.Kind)]; !ok || n.Kind == NodeIL_NONE {
+			err = &IRError{Node: n, Message: fmt.Sprintf("unknown node kind %d", n.Kind)}
+			return false
+		}
+
+		if n.Value != nil {
+			if _, ok := NodeIL_DValue_Kind_name[int32(n.Value.Kind)]; !ok {
+				err = &IRError{Node: n, Message: fmt.Sprintf("unknown value kind %d", n.Value.Kind)}
+				return false
+			}
+		}
+
+		named := n.Value != nil && n.Value.Kind == NodeIL_DValue_STR && n.Value.Str != ""
+
+		switch n.Kind {
+		case NodeIL_ROOT:
+			if n != root {
+				err = &IRError{Node: n, Message: "only the entry node can be a ROOT"}
+			}
+		case NodeIL_VALUE:
+			if n.Value == nil {
+				err = &IRError{Node: n, Message: "value node has no value"}
+			}
+		case NodeIL_FUNC, NodeIL_NAT, NodeIL_VAR:
+			if !named {
+				err = &IRError{Node: n, Message: "node has no name"}
+			}
+		case NodeIL_ASSIGN:
+			if !named {
+				err = &IRError{Node: n, Message: "node has no name"}
+			} else if n.Chained == nil {
+				err = &IRError{Node: n, Message: "assignment has nothing to assign"}
+			}
+		case NodeIL_TERNARY:
+			if len(n.Children) != 3 {
+				err = &IRError{Node: n, Message: "ternary must have a condition and two values"}
+			}
+		case NodeIL_MATCH:
+			if len(n.Children) == 0 {
+				err = &IRError{Node: n, Message: "match has no value"}
+				break
+			}
+			for _, c := range n.Children[1:] {
+				if c.Kind != NodeIL_CASE {
+					err = &IRError{Node: n, Message: "match can only have cases after the value"}
+				}
+			}
+		case NodeIL_CASE:
+			if n.Value == nil && n.SubType != "default" {
+				err = &IRError{Node: n, Message: "case has no value"}
+			}
+		case NodeIL_DEFER:
+			if n.Chained == nil {
+				err = &IRError{Node: n, Message: "defer has nothing to run"}
+			}
+		case NodeIL_BINARY:
+			if !named || !contains(binaryOperators, n.Value.Str) {
+				err = &IRError{Node: n, Message: "binary node has no known operator"}
+			} else if len(n.Children) != 2 {
+				err = &IRError{Node: n, Message: "binary node must have two values"}
+			}
+		}
+
+		return err == nil
+	})
+
+	return err
 }
@@ -1,7 +1,11 @@
 package machine_test
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/maddiesch/machine"
 	"github.com/stretchr/testify/assert"
@@ -75,3 +79,672 @@ func TestCompileSource(t *testing.T) {
 		assert.Equal(t, "Source error (Ln 1, Col 5): failed to decode UTF-8 character", err.Error())
 	})
 }
+
+func TestCompileSourceWithOptions(t *testing.T) {
+	t.Run("given the optimize option", func(t *testing.T) {
+		t.Run("pure stdlib calls with constant arguments are folded", func(t *testing.T) {
+			prog, err := CompileSourceWithOptions(`foo(setf(f1.5) set(bar) baz);`, CompileOptions{Optimize: true})
+
+			require.NoError(t, err)
+
+			folded := &NodeIL{
+				Kind: NodeIL_ROOT,
+				Children: []*NodeIL{
+					{
+						Kind:  NodeIL_FUNC,
+						Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "foo"},
+						Children: []*NodeIL{
+							{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_FLT, Flt: 1.5}},
+							{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "bar"}},
+							{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "baz"}},
+						},
+					},
+				},
+			}
+
+			assert.True(t, NodeCompare(prog.Entry, folded))
+			assert.Equal(t, map[string]uint64{"foo": 1}, prog.FuncCalls)
+		})
+
+		t.Run("calls depending on the machine are not folded", func(t *testing.T) {
+			prog, err := CompileSourceWithOptions(`foo(env(bar));`, CompileOptions{Optimize: true})
+
+			require.NoError(t, err)
+
+			unfolded, err := CompileSource(`foo(env(bar));`)
+
+			require.NoError(t, err)
+
+			assert.True(t, NodeCompare(prog.Entry, unfolded.Entry))
+		})
+
+		t.Run("calls with an interpolated string are not folded", func(t *testing.T) {
+			const src = `return contains("${REGION}" "us");`
+
+			run := func(t *testing.T, opts CompileOptions) interface{} {
+				prog, err := CompileSourceWithOptions(src, opts)
+
+				require.NoError(t, err)
+
+				i := &Implementation{}
+				i.EnableInterpolation(true)
+
+				m := NewSync(i)
+				m.Setenv("REGION", "us-east-1")
+
+				v, err := m.ExecuteValue(prog)
+
+				require.NoError(t, err)
+
+				return v
+			}
+
+			assert.Equal(t, true, run(t, CompileOptions{}))
+			assert.Equal(t, true, run(t, CompileOptions{Optimize: true}))
+		})
+
+		t.Run("calls chained from a group are not folded", func(t *testing.T) {
+			const src = `return (env(A)|env(B)).coalesce();`
+
+			run := func(t *testing.T, opts CompileOptions) interface{} {
+				prog, err := CompileSourceWithOptions(src, opts)
+
+				require.NoError(t, err)
+
+				m := NewSync(&Implementation{})
+				m.Setenv("B", "fallback")
+
+				v, err := m.ExecuteValue(prog)
+
+				require.NoError(t, err)
+
+				return v
+			}
+
+			assert.Equal(t, "fallback", run(t, CompileOptions{}))
+			assert.Equal(t, "fallback", run(t, CompileOptions{Optimize: true}))
+		})
+	})
+}
+
+func TestCompileContext(t *testing.T) {
+	large := strings.Repeat("alert(cpu GT f0.8).page(#team-channel);\n", 10000)
+
+	t.Run("given a canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := CompileContext(ctx, large)
+
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("given a context that timed out", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+
+		<-ctx.Done()
+
+		_, err := CompileContext(ctx, large)
+
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("given a context that isn't done", func(t *testing.T) {
+		prog, err := CompileContext(context.Background(), large)
+
+		require.NoError(t, err)
+		assert.Len(t, prog.Entry.Children, 10000)
+	})
+}
+
+func TestCompileSourceMaxDepth(t *testing.T) {
+	src := `one(two(three(four(five()))));`
+
+	t.Run("given the default depth", func(t *testing.T) {
+		_, err := CompileSource(src)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("given a depth the source exceeds", func(t *testing.T) {
+		_, err := CompileSourceWithOptions(src, CompileOptions{MaxDepth: 3})
+
+		require.Error(t, err)
+
+		_, ok := err.(*SyntaxError)
+
+		require.True(t, ok)
+
+		assert.Contains(t, err.Error(), "Maximum nesting depth of 3 exceeded.")
+	})
+}
+
+func TestCompileSourceWithStats(t *testing.T) {
+	prog, stats, err := CompileSourceWithStats(`foo(bar).baz(f1.5);`, CompileOptions{})
+
+	require.NoError(t, err)
+
+	t.Run("the stats are populated", func(t *testing.T) {
+		assert.True(t, stats.TokenizeDuration > 0)
+		assert.True(t, stats.ParseDuration > 0)
+		assert.Equal(t, 12, stats.TokenCount)
+		assert.Equal(t, 5, stats.NodeCount)
+	})
+
+	t.Run("the program is the same as without stats", func(t *testing.T) {
+		p2, err := CompileSource(`foo(bar).baz(f1.5);`)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+		assert.Equal(t, p2.Source, prog.Source)
+	})
+}
+
+func TestSourceEqual(t *testing.T) {
+	t.Run("given only formatting differences", func(t *testing.T) {
+		ok, err := SourceEqual(
+			"foo(bar  baz).qux(f1.5);\nconst a = env(x);",
+			"; A comment\n  foo( bar baz ).qux( f1.5 );\n\nconst a=env(x);",
+		)
+
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+	})
+
+	t.Run("given different programs", func(t *testing.T) {
+		ok, err := SourceEqual(`foo(bar);`, `foo(baz);`)
+
+		require.NoError(t, err)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("given source that doesn't compile", func(t *testing.T) {
+		_, err := SourceEqual(`foo(bar);`, `foo(bar)|baz();`)
+
+		require.Error(t, err)
+
+		_, ok := err.(*SyntaxError)
+
+		assert.True(t, ok)
+	})
+}
+
+func TestCompileBundle(t *testing.T) {
+	t.Run("given two programs", func(t *testing.T) {
+		progs, err := CompileBundle("foo(bar);\nfoo(baz);\n---\nqux(a);\n")
+
+		require.NoError(t, err)
+		require.Len(t, progs, 2)
+
+		assert.Equal(t, map[string]uint64{"foo": 2}, progs[0].FuncCalls)
+		assert.Equal(t, map[string]uint64{"qux": 1}, progs[1].FuncCalls)
+		assert.NotEqual(t, progs[0].Id, progs[1].Id)
+	})
+
+	t.Run("given empty programs", func(t *testing.T) {
+		progs, err := CompileBundle("---\nfoo(bar);\n---\n\n---")
+
+		require.NoError(t, err)
+
+		assert.Len(t, progs, 1)
+	})
+
+	t.Run("given a program that doesn't compile", func(t *testing.T) {
+		_, err := CompileBundle("foo(bar);\n---\nfoo(bar);\nfoo(bar)|baz();\n")
+
+		require.Error(t, err)
+
+		bErr, ok := err.(*BundleError)
+		require.True(t, ok)
+
+		assert.Equal(t, 2, bErr.Segment)
+
+		sErr, ok := bErr.Err.(*SyntaxError)
+		require.True(t, ok)
+
+		assert.Equal(t, uint32(2), sErr.Token.Line)
+	})
+}
+
+func TestLoadIR(t *testing.T) {
+	loadIR := func(t *testing.T, p *ProgramIL) error {
+		ir, err := p.IR()
+
+		require.NoError(t, err)
+
+		return (&ProgramIL{}).LoadIR(ir)
+	}
+
+	root := func(children ...*NodeIL) *ProgramIL {
+		return &ProgramIL{Entry: &NodeIL{Kind: NodeIL_ROOT, Children: children}}
+	}
+
+	t.Run("given a valid program", func(t *testing.T) {
+		prog, err := CompileSource(load("example.mac"))
+
+		require.NoError(t, err)
+
+		ir, err := prog.IR()
+
+		require.NoError(t, err)
+
+		p2 := &ProgramIL{}
+
+		require.NoError(t, p2.LoadIR(ir))
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+	})
+
+	invalid := map[string]struct {
+		prog *ProgramIL
+		msg  string
+	}{
+		"no entry": {
+			prog: &ProgramIL{Source: "foo();"},
+			msg:  "IR Error: program has no entry node",
+		},
+		"an unknown node kind": {
+			prog: root(&NodeIL{Kind: NodeIL_Kind(99)}),
+			msg:  "IR Error (<99>): unknown node kind 99",
+		},
+		"an assignment without a chained value": {
+			prog: root(&NodeIL{Kind: NodeIL_ASSIGN, SubType: "const", Value: &NodeIL_DValue{Str: "a"}}),
+			msg:  "IR Error (<ASSIGN>): assignment has nothing to assign",
+		},
+		"a value without a value": {
+			prog: root(&NodeIL{Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Str: "foo"}, Children: []*NodeIL{{Kind: NodeIL_VALUE}}}),
+			msg:  "IR Error (<VALUE>): value node has no value",
+		},
+		"a function without a name": {
+			prog: root(&NodeIL{Kind: NodeIL_FUNC}),
+			msg:  "IR Error (<FUNC>): node has no name",
+		},
+		"a binary without an operator": {
+			prog: root(&NodeIL{Kind: NodeIL_BINARY, Children: []*NodeIL{{Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Str: "a"}}, {Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Str: "b"}}}}),
+			msg:  "IR Error (<BINARY>): binary node has no known operator",
+		},
+		"a binary with one value": {
+			prog: root(&NodeIL{Kind: NodeIL_BINARY, Value: &NodeIL_DValue{Str: "+"}, Children: []*NodeIL{{Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Str: "a"}}}}),
+			msg:  "IR Error (<BINARY>): binary node must have two values",
+		},
+		"a nested root": {
+			prog: root(&NodeIL{Kind: NodeIL_ROOT}),
+			msg:  "IR Error (<ROOT>): only the entry node can be a ROOT",
+		},
+	}
+
+	for name, tc := range invalid {
+		t.Run("given "+name, func(t *testing.T) {
+			err := loadIR(t, tc.prog)
+
+			require.Error(t, err)
+
+			_, ok := err.(*IRError)
+
+			assert.True(t, ok)
+			assert.Equal(t, tc.msg, err.Error())
+		})
+	}
+}
+
+func TestProgramHash(t *testing.T) {
+	a, err := CompileSource("foo(bar);")
+
+	require.NoError(t, err)
+
+	b, err := CompileSource("foo(bar);")
+
+	require.NoError(t, err)
+
+	c, err := CompileSource("foo(baz);")
+
+	require.NoError(t, err)
+
+	assert.Len(t, a.Hash(), 32)
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, a.Hash(), c.Hash())
+	assert.NotEqual(t, a.Id, b.Id)
+
+	t.Run("the hash survives IR", func(t *testing.T) {
+		ir, err := a.IR()
+
+		require.NoError(t, err)
+
+		p := &ProgramIL{}
+
+		require.NoError(t, p.LoadIR(ir))
+
+		assert.Equal(t, a.Hash(), p.Hash())
+	})
+}
+
+func TestProgramIRMinimal(t *testing.T) {
+	prog, err := CompileSource(load("example.mac"))
+
+	require.NoError(t, err)
+
+	ir, err := prog.IR()
+
+	require.NoError(t, err)
+
+	min, err := prog.IRMinimal()
+
+	require.NoError(t, err)
+
+	assert.Less(t, len(min), len(ir))
+	assert.NotEmpty(t, prog.Source)
+
+	p2 := &ProgramIL{}
+
+	require.NoError(t, p2.LoadIR(min))
+
+	assert.Equal(t, "", p2.Source)
+	assert.Equal(t, prog.Id, p2.Id)
+	assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+
+	t.Run("the minimal program runs", func(t *testing.T) {
+		prog, err := CompileSource("return format(\"{} {}\" set(a) b);")
+
+		require.NoError(t, err)
+
+		min, err := prog.IRMinimal()
+
+		require.NoError(t, err)
+
+		p := &ProgramIL{}
+
+		require.NoError(t, p.LoadIR(min))
+
+		v, err := NewSync(&Implementation{}).ExecuteValue(p)
+
+		require.NoError(t, err)
+		assert.Equal(t, "a b", v)
+	})
+}
+
+func TestProgramGoLiteral(t *testing.T) {
+	prog, err := CompileSource(load("example.mac"))
+
+	require.NoError(t, err)
+
+	lit, err := prog.GoLiteral()
+
+	require.NoError(t, err)
+
+	require.True(t, strings.HasPrefix(lit, "machine.MustLoadBase64(`"))
+	require.True(t, strings.HasSuffix(lit, "`)"))
+
+	loaded := MustLoadBase64(strings.TrimSuffix(strings.TrimPrefix(lit, "machine.MustLoadBase64(`"), "`)"))
+
+	assert.True(t, NodeCompare(prog.Entry, loaded.Entry))
+	assert.Equal(t, prog.Source, loaded.Source)
+	assert.Equal(t, prog.FuncCalls, loaded.FuncCalls)
+
+	t.Run("given invalid base64", func(t *testing.T) {
+		_, err := LoadBase64("not base64!")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCyclicNodes(t *testing.T) {
+	cyclic := func() *ProgramIL {
+		fn := &NodeIL{Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "set"}}
+		fn.Children = []*NodeIL{{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "a"}}}
+		fn.Chained = &NodeIL{Kind: NodeIL_GROUP, Children: []*NodeIL{fn}}
+
+		return &ProgramIL{
+			Entry:     &NodeIL{Kind: NodeIL_ROOT, Children: []*NodeIL{fn}},
+			FuncCalls: map[string]uint64{"set": 1},
+		}
+	}
+
+	t.Run("executing a cyclic program", func(t *testing.T) {
+		err := NewSync(&Implementation{}).Execute(cyclic())
+
+		require.Error(t, err)
+
+		irErr, ok := err.(*IRError)
+		require.True(t, ok)
+
+		assert.Equal(t, "IR Error (<FUNC>): node is part of a cycle", irErr.Error())
+	})
+
+	t.Run("a node used twice isn't a cycle", func(t *testing.T) {
+		value := &NodeIL{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "a"}}
+		fn := &NodeIL{Kind: NodeIL_FUNC, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "set"}, Children: []*NodeIL{value}}
+
+		prog := &ProgramIL{
+			Entry:     &NodeIL{Kind: NodeIL_ROOT, Children: []*NodeIL{fn, fn}},
+			FuncCalls: map[string]uint64{"set": 2},
+		}
+
+		assert.NoError(t, NewSync(&Implementation{}).Execute(prog))
+	})
+}
+
+func TestChainDepth(t *testing.T) {
+	t.Run("given a 3-deep chained expression", func(t *testing.T) {
+		entry, err := Parse("warn(cpu).notify(#team).page();")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, ChainDepth(entry.Children[0]))
+		assert.Equal(t, 2, ChainDepth(entry.Children[0].Chained))
+	})
+
+	t.Run("given a node without a chain", func(t *testing.T) {
+		entry, err := Parse("page();")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, ChainDepth(entry.Children[0]))
+	})
+
+	t.Run("given a nil node", func(t *testing.T) {
+		assert.Equal(t, 0, ChainDepth(nil))
+	})
+
+	t.Run("given a chain that loops", func(t *testing.T) {
+		a := &NodeIL{Kind: NodeIL_FUNC}
+		a.Chained = &NodeIL{Kind: NodeIL_FUNC, Chained: a}
+
+		assert.Equal(t, 2, ChainDepth(a))
+	})
+}
+
+func TestProgramComplexity(t *testing.T) {
+	prog, err := CompileSource(load("example.mac"))
+
+	require.NoError(t, err)
+
+	c := prog.Complexity()
+
+	assert.Equal(t, 37, c.Nodes)
+	assert.Equal(t, map[NodeIL_Kind]int{
+		NodeIL_ROOT:   1,
+		NodeIL_GROUP:  1,
+		NodeIL_FUNC:   11,
+		NodeIL_VALUE:  21,
+		NodeIL_ASSIGN: 1,
+		NodeIL_VAR:    1,
+		NodeIL_NAT:    1,
+	}, c.Kinds)
+	assert.Equal(t, 4, c.MaxDepth)
+	assert.Equal(t, 1, c.MaxChain)
+
+	t.Run("given chained calls", func(t *testing.T) {
+		prog, err := CompileSource(`a().b().c();`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, prog.Complexity().MaxChain)
+	})
+}
+
+func TestProgramEnvDependencies(t *testing.T) {
+	prog, err := CompileSource("scale-up(env(app-name) cpu GT env(\"cpu limit\"));\nenable(env(app-name) env(set(dynamic)));")
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"app-name", "cpu limit"}, prog.EnvDependencies())
+
+	t.Run("given a program that doesn't read the env", func(t *testing.T) {
+		prog, err := CompileSource(`set(a);`)
+
+		require.NoError(t, err)
+
+		assert.Empty(t, prog.EnvDependencies())
+	})
+}
+
+func TestProgramStringLiterals(t *testing.T) {
+	src := strings.Join([]string{
+		`const token = "sk-1234";`,
+		`slack(#team-channel "token: " + $token);`,
+		`match (env(region)) {`,
+		`"us-east-1": page(us-team);`,
+		`default: page(#team-channel);`,
+		`};`,
+		`enable(scaling true 42);`,
+	}, "\n")
+
+	prog, err := CompileSource(src)
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sk-1234", "#team-channel", "token: ", "region", "us-east-1", "us-team", "scaling"}, prog.StringLiterals())
+}
+
+func TestParse(t *testing.T) {
+	t.Run("given valid source", func(t *testing.T) {
+		src := load("example.mac")
+
+		root, err := Parse(src)
+
+		require.NoError(t, err)
+
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(prog.Entry, root))
+	})
+
+	t.Run("given invalid source", func(t *testing.T) {
+		_, err := Parse(`foo(bar)|baz();`)
+
+		require.Error(t, err)
+
+		_, ok := err.(*SyntaxError)
+
+		assert.True(t, ok)
+	})
+}
+
+func TestTokensString(t *testing.T) {
+	t.Run("given a program with a float, a chain, and a pipe", func(t *testing.T) {
+		out, err := TokensString("(warn(cpu GT f0.8)|page()).notify();")
+
+		require.NoError(t, err)
+
+		expected := strings.Join([]string{
+			`KIND   VALUE     LINE  COLUMN`,
+			`OPEN   ""        1     1`,
+			`VALUE  "warn"    1     2`,
+			`OPEN   ""        1     6`,
+			`VALUE  "cpu"     1     7`,
+			`VALUE  "GT"      1     11`,
+			`VALUE  "f0"      1     14`,
+			`DOT    ""        1     16`,
+			`VALUE  "8"       1     17`,
+			`CLOSE  ""        1     18`,
+			`PIPE   ""        1     19`,
+			`VALUE  "page"    1     20`,
+			`OPEN   ""        1     24`,
+			`CLOSE  ""        1     25`,
+			`CLOSE  ""        1     26`,
+			`DOT    ""        1     27`,
+			`VALUE  "notify"  1     28`,
+			`OPEN   ""        1     34`,
+			`CLOSE  ""        1     35`,
+			`END    ""        1     36`,
+			``,
+		}, "\n")
+
+		assert.Equal(t, expected, out)
+	})
+
+	t.Run("given invalid source", func(t *testing.T) {
+		_, err := TokensString(`foo("abc);`)
+
+		require.Error(t, err)
+
+		_, ok := err.(*SourceError)
+		assert.True(t, ok)
+	})
+}
+
+func TestProgramGeneratedSource(t *testing.T) {
+	// Checks the generated source compiles to a program with the same nodes.
+	recompiles := func(t *testing.T, prog *ProgramIL) {
+		src := prog.GeneratedSource()
+
+		require.NotEmpty(t, src)
+
+		p2, err := CompileSource(src)
+
+		require.NoError(t, err, src)
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry), src)
+	}
+
+	t.Run("given a compiled program", func(t *testing.T) {
+		prog, err := CompileSource(load("example.mac"))
+
+		require.NoError(t, err)
+
+		assert.Equal(t, prog.Source, prog.GeneratedSource())
+
+		recompiles(t, prog)
+	})
+
+	sources := map[string]string{
+		"example":    load("example.mac"),
+		"values":     `set-label(priority "42" 42 -0.5 f0.8 true "true" "say \"hi\"" $name #team-channel);`,
+		"assign":     "const host, port = (split(a)|split(b));\nconst c = \"#team\";\nslack($c const d = warn(cpu));",
+		"chains":     "(alert(cpu GT 600)|recover(LT 500)).page().notify(#team);\n(a()).b();\n_delete(c);",
+		"conditions": "scale-up(app busy() ? \"cpu\" : mem);\nbusy() ? page() : idle() ? sleep() : wake();",
+		"operators":  "slack(#team \"cpu: \" + $cpu + \"%\");\nscale-up(app current() + f0.1 ? up : down);",
+		"match":      "match (env(region)) {\n\"us-east-1\": page(us-team);\n42: page(answer);\ndefault: page(on-call);\n};\npage(done);",
+		"statements": "defer release(lock-id);\n(warn(a)|warn(b));\nreturn env(app-name);",
+		"return":     "page();\nreturn;",
+	}
+
+	for name, src := range sources {
+		src := src
+
+		t.Run("given a program loaded from IR for "+name, func(t *testing.T) {
+			prog, err := CompileSource(src)
+
+			require.NoError(t, err)
+
+			min, err := prog.IRMinimal()
+
+			require.NoError(t, err)
+
+			loaded := &ProgramIL{}
+
+			require.NoError(t, loaded.LoadIR(min))
+			require.Empty(t, loaded.Source)
+
+			recompiles(t, loaded)
+		})
+	}
+}
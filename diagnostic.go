@@ -0,0 +1,165 @@
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity is how serious a diagnostic is.
+type Severity int
+
+// SeverityError is a problem that stops the source from compiling.
+const SeverityError Severity = 1
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Position is a location in the source. Lines and columns start at 1.
+type Position struct {
+	Line   uint32 `json:"line"`
+	Column uint32 `json:"column"`
+}
+
+// Range is the span of source a diagnostic applies to. The end is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic is a single problem found in the source.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Range    Range
+	Code     string
+}
+
+// MarshalJSON encodes the diagnostic with the severity as a string.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Severity string `json:"severity"`
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Range    Range  `json:"range"`
+	}{
+		Severity: d.Severity.String(),
+		Code:     d.Code,
+		Message:  d.Message,
+		Range:    d.Range,
+	})
+}
+
+// Diagnose compiles the source and returns the first error found as a diagnostic. The compiler stops at the first
+// error, so there's never more than one. An empty slice means the source compiled.
+func Diagnose(src string) (diags []Diagnostic) {
+	diags = []Diagnostic{}
+
+	defer func() {
+		if r := recover(); r != nil {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%v", r),
+				Range:    Range{Start: Position{Line: 1, Column: 1}, End: Position{Line: 1, Column: 2}},
+				Code:     "InternalError",
+			})
+		}
+	}()
+
+	_, err := CompileSource(src)
+	if err != nil {
+		diags = append(diags, diagnostic(err))
+	}
+
+	return diags
+}
+
+func diagnostic(err error) Diagnostic {
+	switch err := err.(type) {
+	case *SyntaxError:
+		start := Position{Line: err.Token.Line, Column: err.Token.Column}
+		width := uint32(utf8.RuneCountInString(err.Token.Value))
+		if width == 0 {
+			width = 1
+		}
+
+		return Diagnostic{
+			Severity: SeverityError,
+			Message:  err.Message,
+			Range:    Range{Start: start, End: Position{Line: start.Line, Column: start.Column + width}},
+			Code:     "SyntaxError",
+		}
+	case *SourceError:
+		start := Position{Line: err.Line, Column: err.Column}
+
+		return Diagnostic{
+			Severity: SeverityError,
+			Message:  err.Message,
+			Range:    Range{Start: start, End: Position{Line: start.Line, Column: start.Column + 1}},
+			Code:     "SourceError",
+		}
+	default:
+		return Diagnostic{
+			Severity: SeverityError,
+			Message:  err.Error(),
+			Code:     "CompileError",
+		}
+	}
+}
+
+// FormatError renders the error with the line of source it happened on and a caret under the column.
+//
+// If the error doesn't have a location in the source, or the location is outside of the source, only the error
+// message is returned.
+func FormatError(src string, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	msg := err.Error()
+
+	start := diagnostic(err).Range.Start
+	if start.Line == 0 || start.Column == 0 {
+		return msg
+	}
+
+	lines := strings.Split(src, "\n")
+	if int(start.Line) > len(lines) {
+		return msg
+	}
+
+	line := []rune(strings.TrimRight(lines[start.Line-1], "\r"))
+	if int(start.Column) > len(line)+1 {
+		return msg
+	}
+
+	// Keep any tabs before the column so the caret lines up with the source.
+	pad := strings.Builder{}
+	for _, r := range line[:start.Column-1] {
+		if r == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+
+	num := fmt.Sprintf("%d", start.Line)
+	gutter := strings.Repeat(" ", len(num))
+
+	b := strings.Builder{}
+	b.WriteString(msg)
+	b.WriteRune('\n')
+	fmt.Fprintf(&b, "%s--> %d:%d\n", gutter, start.Line, start.Column)
+	fmt.Fprintf(&b, "%s |\n", gutter)
+	fmt.Fprintf(&b, "%s | %s\n", num, string(line))
+	fmt.Fprintf(&b, "%s | %s^", gutter, pad.String())
+
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package machine_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	t.Run("given valid source", func(t *testing.T) {
+		assert.Empty(t, Diagnose(load("example.mac")))
+	})
+
+	t.Run("given a syntax error", func(t *testing.T) {
+		diags := Diagnose(`foo(bar)|baz();`)
+
+		require.Len(t, diags, 1)
+
+		assert.Equal(t, Diagnostic{
+			Severity: SeverityError,
+			Message:  "Unexpected Pipe. You can't pipe outside of a group.",
+			Range:    Range{Start: Position{Line: 1, Column: 9}, End: Position{Line: 1, Column: 10}},
+			Code:     "SyntaxError",
+		}, diags[0])
+	})
+
+	t.Run("given a source error", func(t *testing.T) {
+		diags := Diagnose("foo(bar)\nbaz();")
+
+		require.Len(t, diags, 1)
+
+		assert.Equal(t, "SourceError", diags[0].Code)
+		assert.Equal(t, Position{Line: 1, Column: 8}, diags[0].Range.Start)
+	})
+
+	t.Run("marshaling to JSON", func(t *testing.T) {
+		diags := Diagnose(`foo(bar)|baz();`)
+
+		require.Len(t, diags, 1)
+
+		b, err := json.Marshal(diags[0])
+
+		require.NoError(t, err)
+
+		assert.JSONEq(t, `{
+			"severity": "error",
+			"code": "SyntaxError",
+			"message": "Unexpected Pipe. You can't pipe outside of a group.",
+			"range": {"start": {"line": 1, "column": 9}, "end": {"line": 1, "column": 10}}
+		}`, string(b))
+	})
+}
+
+func TestFormatError(t *testing.T) {
+	t.Run("given a syntax error", func(t *testing.T) {
+		src := "foo(bar);\n\tbaz(qux)|quux();"
+
+		_, err := CompileSource(src)
+
+		require.Error(t, err)
+
+		assert.Equal(t, err.Error()+"\n"+
+			" --> 2:10\n"+
+			"  |\n"+
+			"2 | \tbaz(qux)|quux();\n"+
+			"  | \t        ^", FormatError(src, err))
+	})
+
+	t.Run("given an error without a location", func(t *testing.T) {
+		err := &RuntimeError{Code: "Fatal", Message: "boom"}
+
+		assert.Equal(t, err.Error(), FormatError("foo();", err))
+	})
+
+	t.Run("given a location outside of the source", func(t *testing.T) {
+		err := &SourceError{Message: "boom", Line: 10, Column: 2}
+
+		assert.Equal(t, err.Error(), FormatError("foo();", err))
+	})
+}
@@ -0,0 +1,142 @@
+package machine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GeneratedSource returns the program's source, as it was regenerated by the compiler. It's normalized instead of the
+// verbatim source that was compiled, but compiled with the same options it builds a program with equal nodes.
+//
+// A program that was loaded from IR without its source has the source generated from its nodes.
+func (p *ProgramIL) GeneratedSource() string {
+	if p.Source != "" {
+		return p.Source
+	}
+	if p.Entry == nil {
+		return ""
+	}
+
+	b := strings.Builder{}
+	writeStatements(&b, p.Entry.Children)
+
+	return b.String()
+}
+
+// Writes each node as a statement ending with a `;`.
+func writeStatements(b *strings.Builder, nodes []*NodeIL) {
+	for _, n := range nodes {
+		writeNode(b, n)
+		b.WriteString(";\n")
+	}
+}
+
+// Writes the node, and the nodes it's chained to.
+func writeNode(b *strings.Builder, n *NodeIL) {
+	switch n.Kind {
+	case NodeIL_FUNC, NodeIL_NAT:
+		b.WriteString(n.Value.Str)
+		b.WriteRune('(')
+		for i, c := range n.Children {
+			if i > 0 {
+				b.WriteRune(' ')
+			}
+			writeNode(b, c)
+		}
+		b.WriteRune(')')
+	case NodeIL_GROUP:
+		b.WriteRune('(')
+		for i, c := range n.Children {
+			if i > 0 {
+				b.WriteRune('|')
+			}
+			writeNode(b, c)
+		}
+		b.WriteRune(')')
+	case NodeIL_VALUE:
+		writeValue(b, n.Value)
+	case NodeIL_VAR:
+		b.WriteRune('$')
+		b.WriteString(n.Value.Str)
+	case NodeIL_ASSIGN:
+		b.WriteString(n.SubType)
+		b.WriteRune(' ')
+		b.WriteString(strings.Replace(n.Value.Str, ",", ", ", -1))
+		b.WriteString(" = ")
+		writeNode(b, n.Chained)
+		return
+	case NodeIL_RETURN:
+		b.WriteString("return")
+		if n.Chained != nil {
+			b.WriteRune(' ')
+			writeNode(b, n.Chained)
+		}
+		return
+	case NodeIL_DEFER:
+		b.WriteString("defer ")
+		writeNode(b, n.Chained)
+		return
+	case NodeIL_TERNARY:
+		writeNode(b, n.Children[0])
+		b.WriteString(" ? ")
+		writeOperand(b, n.Children[1])
+		b.WriteString(" : ")
+		writeNode(b, n.Children[2])
+	case NodeIL_BINARY:
+		// The operator is applied from the left, so only a nested right value needs parens.
+		if n.Children[0].Kind == NodeIL_TERNARY {
+			writeGrouped(b, n.Children[0])
+		} else {
+			writeNode(b, n.Children[0])
+		}
+		fmt.Fprintf(b, " %s ", n.Value.Str)
+		writeOperand(b, n.Children[1])
+	case NodeIL_MATCH:
+		b.WriteString("match (")
+		writeNode(b, n.Children[0])
+		b.WriteString(") {\n")
+		for _, c := range n.Children[1:] {
+			if c.SubType == "default" {
+				b.WriteString("default")
+			} else {
+				writeValue(b, c.Value)
+			}
+			b.WriteString(": ")
+			writeStatements(b, c.Children)
+		}
+		b.WriteRune('}')
+	}
+
+	if n.Chained != nil {
+		b.WriteRune('.')
+		writeNode(b, n.Chained)
+	}
+}
+
+// Writes a value that an operator or a ternary's true value applies to, in parens if it would continue past it.
+func writeOperand(b *strings.Builder, n *NodeIL) {
+	if n.Kind == NodeIL_TERNARY || n.Kind == NodeIL_BINARY {
+		writeGrouped(b, n)
+		return
+	}
+	writeNode(b, n)
+}
+
+func writeGrouped(b *strings.Builder, n *NodeIL) {
+	b.WriteRune('(')
+	writeNode(b, n)
+	b.WriteRune(')')
+}
+
+// Writes the value so it's parsed as the same kind. Strings are always quoted.
+func writeValue(b *strings.Builder, v *NodeIL_DValue) {
+	switch v.Kind {
+	case NodeIL_DValue_STR:
+		b.WriteString(quote(v.Str))
+	case NodeIL_DValue_FLT:
+		b.WriteString(strconv.FormatFloat(v.Flt, 'f', -1, 64))
+	case NodeIL_DValue_BOOL:
+		b.WriteString(strconv.FormatBool(v.Bool))
+	}
+}
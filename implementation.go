@@ -15,35 +15,320 @@ type Implementation struct {
 	funcs  map[string]*iFunc
 	frozen bool
 	stdInj bool
+	truthy bool
+	interp bool
+
+	// Function names are matched without case.
+	insensitive bool
+
+	// The order a native function and a function with the same name are resolved in.
+	precedence Precedence
+
+	// Converts a function handler's panic into the error the program fails with.
+	panicConv func(interface{}) error
+
+	// Native functions the machine won't run.
+	disabled map[string]bool
 }
 
 // Func adds a function handler to the implementation
 func (i *Implementation) Func(name string, handler interface{}) {
-	if stdlibHasFunc(name) {
+	if stdlibHasFunc(i.key(name)) {
 		panic(fmt.Errorf("attempting to override as stdlib function is not allowed '%s'", name))
 	}
 
 	i.addFunc(false, name, "", handler)
 }
 
-// Functions returns a list of function documentation.
-func (i *Implementation) Functions() []string {
-	i.mergeStdlib()
+// FuncWithSignature adds a function handler to the implementation with names for each of its parameters.
+//
+// The names are used in the function documentation and argument errors. A context parameter isn't named.
+func (i *Implementation) FuncWithSignature(name string, paramNames []string, handler interface{}) {
+	if stdlibHasFunc(i.key(name)) {
+		panic(fmt.Errorf("attempting to override as stdlib function is not allowed '%s'", name))
+	}
+
+	i.addFuncWithParams(false, name, "", paramNames, handler)
+}
+
+// FuncWithDoc adds a function handler to the implementation with a description that's included in
+// FunctionsDetailed.
+func (i *Implementation) FuncWithDoc(name string, desc string, handler interface{}) {
+	if stdlibHasFunc(i.key(name)) {
+		panic(fmt.Errorf("attempting to override as stdlib function is not allowed '%s'", name))
+	}
+
+	i.addFunc(false, name, desc, handler)
+}
+
+// Thunk is a lazy argument. Calling it evaluates the argument the first time, and returns the same result after that.
+//
+// It can only be called while the function it was passed to is running.
+type Thunk func() (interface{}, error)
+
+var thunkType = reflect.TypeOf(Thunk(nil))
+
+// FuncLazy adds a function handler to the implementation whose arguments at the lazy positions aren't evaluated before
+// it's called. The parameter at each position must be a Thunk, and the handler decides if the argument is evaluated.
+//
+// Positions start at zero and don't include a context parameter.
+func (i *Implementation) FuncLazy(name string, lazyPositions []int, handler interface{}) {
+	if stdlibHasFunc(i.key(name)) {
+		panic(fmt.Errorf("attempting to override as stdlib function is not allowed '%s'", name))
+	}
+
+	fn := i.newFunc(false, name, "", nil, handler)
+
+	fn.lazy = make(map[int]bool, len(lazyPositions))
+	for _, pos := range lazyPositions {
+		if pos < 0 || pos >= fn.recC || fn.in(pos) != thunkType {
+			panic(fmt.Errorf("function '%s' parameter %d must be a machine.Thunk to be lazy", name, pos))
+		}
+		fn.lazy[pos] = true
+	}
+
+	i.register(fn)
+}
+
+// Alias adds another name for a function that's already been added to the implementation.
+func (i *Implementation) Alias(existing string, alias string) {
+	if stdlibHasFunc(i.key(existing)) {
+		panic(fmt.Errorf("attempting to alias a stdlib function is not allowed '%s'", existing))
+	}
+	if stdlibHasFunc(i.key(alias)) {
+		panic(fmt.Errorf("attempting to override as stdlib function is not allowed '%s'", alias))
+	}
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	fn, ok := i.funcs[i.key(existing)]
+	if !ok {
+		panic(fmt.Errorf("attempting to alias a function that doesn't exist '%s'", existing))
+	}
+	if _, ok := i.funcs[i.key(alias)]; ok {
+		panic(fmt.Errorf("attempting to redefine a function with name '%s'", alias))
+	}
+
+	// The alias shares the handler and its reflected type, only the name is different.
+	n := iFunc(*fn)
+	n.name = alias
+	i.funcs[i.key(alias)] = &n
+}
+
+// SetCaseInsensitive matches function names without case, so `Alert` and `alert` call the same function.
+//
+// Functions that only differ by case can't both be added, and enabling it panics if they already have been.
+func (i *Implementation) SetCaseInsensitive(enabled bool) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := func(name string) string {
+		if enabled {
+			return strings.ToLower(name)
+		}
+		return name
+	}
+
+	funcs := make(map[string]*iFunc, len(i.funcs))
+	for _, fn := range i.funcs {
+		if f, ok := funcs[key(fn.name)]; ok {
+			panic(fmt.Errorf("functions '%s' and '%s' only differ by case", f.name, fn.name))
+		}
+		funcs[key(fn.name)] = fn
+	}
+
+	i.funcs = funcs
+	i.insensitive = enabled
+}
+
+// Returns the name functions are stored under.
+func (i *Implementation) key(name string) string {
+	if i.insensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// EnableTruthiness allows conditions to be any value instead of only a bool.
+//
+// A string is true if it's not empty, and a number is true if it's not zero.
+func (i *Implementation) EnableTruthiness(enabled bool) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.truthy = enabled
+}
+
+// EnableInterpolation replaces `${NAME}` in strings with the environment variable NAME when the program is run.
+//
+// A missing variable is replaced with an empty string, and `$${` is replaced with a literal `${`.
+func (i *Implementation) EnableInterpolation(enabled bool) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.interp = enabled
+}
+
+// Precedence is the order a native function, like `_delete`, and a function added to the implementation with the same
+// name are resolved in.
+//
+// The compiler always builds a call to a native function's name as a native call. The machine then resolves it in
+// this order when it's run. Stdlib functions can't share a name with a function added to the implementation, so they
+// aren't affected.
+type Precedence int
+
+const (
+	// NativeFirst always calls the native function. A function with the same name is never called. This is the default.
+	NativeFirst Precedence = iota
+
+	// FuncFirst calls the implementation's function with the same name when there is one, instead of the native function.
+	FuncFirst
+)
+
+// SetPrecedence sets the order a native function and a function with the same name are resolved in.
+func (i *Implementation) SetPrecedence(p Precedence) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.precedence = p
+}
+
+// SetPanicConverter sets the function that converts a function handler's panic into the error the program fails with.
+//
+// A nil converter uses the default, a RuntimeError with the code "HandlerPanic".
+func (i *Implementation) SetPanicConverter(fn func(recovered interface{}) error) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.panicConv = fn
+}
+
+// Returns the function that's called instead of the native function, if the precedence allows one.
+func nativeOverride(p Precedence, lookup lookupFunc, name string) (*iFunc, bool) {
+	if p != FuncFirst {
+		return nil, false
+	}
+
+	fn, err := lookup(name)
+	if err != nil || fn.std {
+		return nil, false
+	}
+
+	return fn, true
+}
+
+// DisableNative stops programs that call the native function from running.
+func (i *Implementation) DisableNative(name string) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+	if !contains(nativeFunctionNames, name) {
+		panic(fmt.Errorf("no native function named '%s'", name))
+	}
 
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.disabled == nil {
+		i.disabled = make(map[string]bool, 0)
+	}
+	i.disabled[name] = true
+}
+
+// Checks that the native function exists and hasn't been disabled.
+func (i *Implementation) hasNative(name string) bool {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
-	str := make([]string, 0)
+	return contains(nativeFunctionNames, name) && !i.disabled[name]
+}
+
+// Functions returns a list of function documentation.
+//
+// The stdlib functions are always included, but listing the functions doesn't modify the implementation.
+func (i *Implementation) Functions() []string {
+	i.mu.RLock()
+	str := make([]string, 0, len(i.funcs))
 	for _, f := range i.funcs {
 		str = append(str, f.syntax())
 	}
+	stdInj := i.stdInj
+	i.mu.RUnlock()
+
+	if !stdInj {
+		for _, f := range stdlib().funcs {
+			str = append(str, f.syntax())
+		}
+	}
 
 	sort.Strings(str)
 
 	return str
 }
 
+// FunctionDoc is the documentation for a function.
+type FunctionDoc struct {
+	Name        string
+	Signature   string
+	Description string
+}
+
+// FunctionsDetailed returns the documentation for every function, sorted by name.
+//
+// Like Functions, the stdlib functions are always included.
+func (i *Implementation) FunctionsDetailed() []FunctionDoc {
+	i.mu.RLock()
+	docs := make([]FunctionDoc, 0, len(i.funcs))
+	for _, f := range i.funcs {
+		docs = append(docs, f.doc())
+	}
+	stdInj := i.stdInj
+	i.mu.RUnlock()
+
+	if !stdInj {
+		for _, f := range stdlib().funcs {
+			docs = append(docs, f.doc())
+		}
+	}
+
+	sort.Slice(docs, func(a, b int) bool { return docs[a].Name < docs[b].Name })
+
+	return docs
+}
+
 func (i *Implementation) addFunc(std bool, name string, desc string, handler interface{}) {
+	i.addFuncWithParams(std, name, desc, nil, handler)
+}
+
+func (i *Implementation) addFuncWithParams(std bool, name string, desc string, params []string, handler interface{}) {
+	i.register(i.newFunc(std, name, desc, params, handler))
+}
+
+func (i *Implementation) newFunc(std bool, name string, desc string, params []string, handler interface{}) *iFunc {
 	if i.isFrozen() {
 		panic(fmt.Errorf("implementation is frozen, unable to modify"))
 	}
@@ -74,6 +359,13 @@ func (i *Implementation) addFunc(std bool, name string, desc string, handler int
 		}
 	}
 
+	if params != nil {
+		if len(params) != fn.recC {
+			panic(fmt.Errorf("function '%s' has %d parameters but %d names were given", name, fn.recC, len(params)))
+		}
+		fn.params = params
+	}
+
 	switch tp.NumOut() {
 	case 0:
 	case 1:
@@ -94,16 +386,29 @@ func (i *Implementation) addFunc(std bool, name string, desc string, handler int
 		panic(fmt.Errorf("function for '%s' can't return more than 2 arguments", name))
 	}
 
+	return fn
+}
+
+func (i *Implementation) register(fn *iFunc) {
+	if i.isFrozen() {
+		panic(fmt.Errorf("implementation is frozen, unable to modify"))
+	}
+
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
+	name := fn.name
+
 	if i.funcs == nil {
 		i.funcs = make(map[string]*iFunc, 0)
 	}
-	if _, ok := i.funcs[name]; ok {
+	if f, ok := i.funcs[i.key(name)]; ok {
+		if f.name != name {
+			panic(fmt.Errorf("attempting to define a function '%s' that only differs by case from '%s'", name, f.name))
+		}
 		panic(fmt.Errorf("attempting to redefine a function with name '%s'", name))
 	}
-	i.funcs[name] = fn
+	i.funcs[i.key(name)] = fn
 }
 
 type lookupFunc func(string) (*iFunc, error)
@@ -112,7 +417,7 @@ func (i *Implementation) lookup(name string) (*iFunc, error) {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
-	if i, ok := i.funcs[name]; ok {
+	if i, ok := i.funcs[i.key(name)]; ok {
 		return i, nil
 	}
 	return nil, &RuntimeError{
@@ -129,10 +434,20 @@ func (i *Implementation) dup() *Implementation {
 		n := iFunc(*f)
 		funcs[name] = &n
 	}
+	disabled := make(map[string]bool, len(i.disabled))
+	for name := range i.disabled {
+		disabled[name] = true
+	}
 	return &Implementation{
-		funcs:  funcs,
-		frozen: true,
-		stdInj: i.stdInj,
+		funcs:       funcs,
+		frozen:      true,
+		stdInj:      i.stdInj,
+		truthy:      i.truthy,
+		interp:      i.interp,
+		insensitive: i.insensitive,
+		precedence:  i.precedence,
+		panicConv:   i.panicConv,
+		disabled:    disabled,
 	}
 }
 
@@ -190,15 +505,85 @@ type iFunc struct {
 	retC   int
 	rRetC  int
 	tp     reflect.Type
+	params []string
+
+	// The arguments that are passed as a Thunk instead of being evaluated first.
+	lazy map[int]bool
 }
 
-func (fn *iFunc) call(ctx context.Context, args []reflect.Value) (reflect.Value, error) {
-	if len(args) != fn.recC {
-		return reflect.Value{}, &RuntimeError{
-			Code:    "ArgumentError",
-			Message: fmt.Sprintf("Attempting to call '%s' with %d arguments. Expected %d", fn.name, len(args), fn.recC),
+// Checks that the number of arguments can be passed to the function.
+func (fn *iFunc) arity(count int) bool {
+	if fn.tp.IsVariadic() {
+		return count >= fn.recC-1
+	}
+	return count == fn.recC
+}
+
+// Returns the error for calling the function with the wrong number of arguments.
+func (fn *iFunc) arityErr(count int) *RuntimeError {
+	msg := fmt.Sprintf("Attempting to call '%s' with %d arguments. Expected %d", fn.name, count, fn.recC)
+	if fn.tp.IsVariadic() {
+		msg = fmt.Sprintf("Attempting to call '%s' with %d arguments. Expected at least %d", fn.name, count, fn.recC-1)
+	}
+	if fn.params != nil && count < fn.recC {
+		msg = fmt.Sprintf("%s, missing '%s'", msg, strings.Join(fn.params[count:], "', '"))
+	}
+
+	return &RuntimeError{
+		Code:    "ArgumentError",
+		Message: msg,
+	}
+}
+
+// Returns the parameter type for the argument at the index. Arguments past the last parameter of a variadic function
+// are the type of the variadic slice's elements.
+func (fn *iFunc) in(i int) reflect.Type {
+	if fn.recCxt {
+		i++
+	}
+	if fn.tp.IsVariadic() && i >= fn.rRecC-1 {
+		return fn.tp.In(fn.rRecC - 1).Elem()
+	}
+	return fn.tp.In(i)
+}
+
+func (fn *iFunc) call(ctx context.Context, args []reflect.Value, convert func(interface{}) error) (ret reflect.Value, err error) {
+	// A function with named parameters can also be called with a single map of its arguments by name.
+	if m, ok := fn.namedMap(args); ok {
+		if args, err = fn.byName(m); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	if !fn.arity(len(args)) {
+		return reflect.Value{}, fn.arityErr(len(args))
+	}
+
+	coerced := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		in := fn.in(i)
+
+		v, err := coerce(arg, in)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		// Check before calling so a mismatch is an error instead of a panic from reflect.
+		if !v.IsValid() || !v.Type().AssignableTo(in) {
+			got := "nil"
+			if v.IsValid() {
+				got = v.Type().String()
+			}
+
+			return reflect.Value{}, &RuntimeError{
+				Code:    "ArgumentTypeError",
+				Message: fmt.Sprintf("arg %d of '%s': expected %s, got %s", i+1, fn.name, in.String(), got),
+			}
 		}
+
+		coerced[i] = v
 	}
+	args = coerced
 
 	fnV := reflect.ValueOf(fn.impl)
 
@@ -206,6 +591,12 @@ func (fn *iFunc) call(ctx context.Context, args []reflect.Value) (reflect.Value,
 		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
 	}
 
+	defer func() { // A panicking handler fails the program instead of the machine.
+		if r := recover(); r != nil {
+			ret, err = reflect.Value{}, fn.panicError(r, convert)
+		}
+	}()
+
 	out := fnV.Call(args)
 
 	// Nothing to return
@@ -240,6 +631,92 @@ func (fn *iFunc) call(ctx context.Context, args []reflect.Value) (reflect.Value,
 	}
 }
 
+// Returns the map of arguments by name, when a function with named parameters is called with a single map that can't
+// be its first argument.
+func (fn *iFunc) namedMap(args []reflect.Value) (reflect.Value, bool) {
+	if fn.params == nil || fn.recC == 0 || fn.tp.IsVariadic() || len(args) != 1 {
+		return reflect.Value{}, false
+	}
+
+	v := args[0]
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, false
+	}
+	if v.Type().AssignableTo(fn.in(0)) {
+		return reflect.Value{}, false
+	}
+
+	return v, true
+}
+
+// Returns the arguments from the map in the order of the function's parameters. Every parameter must be in the map,
+// and every key must be a parameter.
+func (fn *iFunc) byName(m reflect.Value) ([]reflect.Value, error) {
+	args := make([]reflect.Value, len(fn.params))
+	missing := []string{}
+
+	for i, name := range fn.params {
+		v := m.MapIndex(reflect.ValueOf(name).Convert(m.Type().Key()))
+		if !v.IsValid() {
+			missing = append(missing, name)
+			continue
+		}
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			v = v.Elem()
+		}
+		args[i] = v
+	}
+
+	if len(missing) > 0 {
+		return nil, &RuntimeError{
+			Code:    "ArgumentError",
+			Message: fmt.Sprintf("Attempting to call '%s' with named arguments, missing '%s'", fn.name, strings.Join(missing, "', '")),
+		}
+	}
+
+	extra := []string{}
+	for _, k := range m.MapKeys() {
+		if !contains(fn.params, k.String()) {
+			extra = append(extra, k.String())
+		}
+	}
+	sort.Strings(extra)
+
+	if len(extra) > 0 {
+		return nil, &RuntimeError{
+			Code:    "ArgumentError",
+			Message: fmt.Sprintf("Attempting to call '%s' with named arguments, unknown '%s'", fn.name, strings.Join(extra, "', '")),
+		}
+	}
+
+	return args, nil
+}
+
+// Converts the recovered value of a panicking handler to an error.
+func (fn *iFunc) panicError(r interface{}, convert func(interface{}) error) error {
+	if convert != nil {
+		if err := convert(r); err != nil {
+			return err
+		}
+	}
+
+	return &RuntimeError{
+		Code:    "HandlerPanic",
+		Message: fmt.Sprintf("function '%s' panicked: %v", fn.name, r),
+	}
+}
+
+func (fn *iFunc) doc() FunctionDoc {
+	return FunctionDoc{
+		Name:        fn.name,
+		Signature:   fn.syntax(),
+		Description: fn.desc,
+	}
+}
+
 func (fn *iFunc) syntax() string {
 	b := strings.Builder{}
 
@@ -254,9 +731,26 @@ func (fn *iFunc) syntax() string {
 
 			in := fn.tp.In(i)
 
-			b.WriteString(in.String())
+			if fn.params != nil {
+				if fn.recCxt {
+					b.WriteString(fn.params[i-1])
+				} else {
+					b.WriteString(fn.params[i])
+				}
+				b.WriteRune(' ')
+			}
+
+			if fn.tp.IsVariadic() && i == fn.rRecC-1 {
+				b.WriteString("...")
+				b.WriteString(in.Elem().String())
+			} else {
+				b.WriteString(in.String())
+			}
 
 			if i != fn.rRecC-1 {
+				if fn.params != nil {
+					b.WriteRune(',')
+				}
 				b.WriteRune(' ')
 			}
 		}
@@ -0,0 +1,616 @@
+package machine_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImplementationFuncWithSignature(t *testing.T) {
+	i := &Implementation{}
+
+	i.FuncWithSignature("alert", []string{"metric", "operator", "value"}, func(metric string, operator string, value string) error {
+		return nil
+	})
+
+	t.Run("the documentation includes the parameter names", func(t *testing.T) {
+		assert.Contains(t, i.Functions(), "alert(metric string, operator string, value string);")
+	})
+
+	t.Run("an arity mismatch names the missing parameters", func(t *testing.T) {
+		err := Run(i, `alert(cpu GT);`)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <ArgumentError> Attempting to call 'alert' with 2 arguments. Expected 3, missing 'value'", err.Error())
+	})
+
+	t.Run("given the wrong number of names", func(t *testing.T) {
+		assert.Panics(t, func() {
+			i.FuncWithSignature("warn", []string{"metric"}, func(metric string, value string) {})
+		})
+	})
+}
+
+func TestImplementationCoercion(t *testing.T) {
+	t.Run("time.Duration", func(t *testing.T) {
+		var out time.Duration
+
+		i := &Implementation{}
+		i.Func("wait", func(d time.Duration) {
+			out = d
+		})
+
+		t.Run("from a float is the number of seconds", func(t *testing.T) {
+			err := Run(i, `wait(f1.5);`)
+
+			require.NoError(t, err)
+			assert.Equal(t, 1500*time.Millisecond, out)
+		})
+
+		t.Run("from a string is parsed", func(t *testing.T) {
+			err := Run(i, `wait(30s);`)
+
+			require.NoError(t, err)
+			assert.Equal(t, 30*time.Second, out)
+		})
+
+		t.Run("from an invalid string", func(t *testing.T) {
+			err := Run(i, `wait(soon);`)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "<ArgumentTypeError> unable to convert soon to time.Duration")
+		})
+	})
+
+	t.Run("time.Time from an RFC3339 string", func(t *testing.T) {
+		var out time.Time
+
+		i := &Implementation{}
+		i.Func("at", func(t time.Time) {
+			out = t
+		})
+
+		err := Run(i, `at(2019-10-12T07:20:50Z);`)
+
+		require.NoError(t, err)
+		assert.True(t, time.Date(2019, 10, 12, 7, 20, 50, 0, time.UTC).Equal(out))
+	})
+}
+
+type testMetric string
+
+type testLevel float64
+
+func TestImplementationNamedTypeCoercion(t *testing.T) {
+	var metric testMetric
+	var level testLevel
+	var ratio float32
+
+	i := &Implementation{}
+	i.Func("metric", func(m testMetric) { metric = m })
+	i.Func("level", func(l testLevel) { level = l })
+	i.Func("ratio", func(r float32) { ratio = r })
+
+	t.Run("given a named string type", func(t *testing.T) {
+		require.NoError(t, Run(i, `metric(cpu);`))
+
+		assert.Equal(t, testMetric("cpu"), metric)
+	})
+
+	t.Run("given a named float type", func(t *testing.T) {
+		require.NoError(t, Run(i, `level(f2.5);`))
+
+		assert.Equal(t, testLevel(2.5), level)
+	})
+
+	t.Run("given a float32", func(t *testing.T) {
+		require.NoError(t, Run(i, `ratio(f0.5);`))
+
+		assert.Equal(t, float32(0.5), ratio)
+	})
+
+	t.Run("given a number larger than a float32", func(t *testing.T) {
+		err := Run(i, `ratio(1000000000000000000000000000000000000000);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentTypeError> unable to convert 1000000000000000000000000000000000000000 to float32: overflows float32")
+	})
+}
+
+func TestImplementationFunctions(t *testing.T) {
+	i := &Implementation{}
+	i.Func("alert", func(metric string) {})
+
+	expected := i.Functions()
+
+	assert.Contains(t, expected, "alert(string);")
+	assert.Contains(t, expected, "set(string) string;")
+
+	t.Run("listing from many goroutines", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		results := make([][]string, 50)
+
+		for n := range results {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				results[n] = i.Functions()
+			}(n)
+		}
+
+		wg.Wait()
+
+		for _, r := range results {
+			assert.Equal(t, expected, r)
+		}
+	})
+
+	t.Run("listing doesn't prevent adding functions", func(t *testing.T) {
+		i.Func("warn", func(metric string) {})
+
+		assert.Contains(t, i.Functions(), "warn(string);")
+	})
+}
+
+func TestImplementationIntCoercion(t *testing.T) {
+	var count int
+	var small int32
+	var size uint8
+
+	i := &Implementation{}
+	i.Func("count", func(n int) { count = n })
+	i.Func("small", func(n int32) { small = n })
+	i.Func("size", func(n uint8) { size = n })
+
+	t.Run("given a whole number", func(t *testing.T) {
+		require.NoError(t, Run(i, `count(42);`))
+		require.NoError(t, Run(i, `small(-7);`))
+		require.NoError(t, Run(i, `size(255);`))
+
+		assert.Equal(t, 42, count)
+		assert.Equal(t, int32(-7), small)
+		assert.Equal(t, uint8(255), size)
+	})
+
+	t.Run("given a number that isn't whole", func(t *testing.T) {
+		err := Run(i, `count(1.5);`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <ArgumentTypeError> unable to convert 1.5 to int: not a whole number", err.Error())
+	})
+
+	t.Run("given a number larger than an int32", func(t *testing.T) {
+		err := Run(i, `small(2147483648);`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <ArgumentTypeError> unable to convert 2147483648 to int32: overflows int32", err.Error())
+	})
+
+	t.Run("given a negative number for an unsigned int", func(t *testing.T) {
+		err := Run(i, `size(-1);`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <ArgumentTypeError> unable to convert -1 to uint8: overflows uint8", err.Error())
+	})
+}
+
+func TestImplementationArgumentTypes(t *testing.T) {
+	i := &Implementation{}
+	i.Func("alert", func(metric string, operator string, value float64) {})
+
+	t.Run("given a float where a string is expected", func(t *testing.T) {
+		err := Run(i, `alert(cpu 1.5 90);`)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <ArgumentTypeError> arg 2 of 'alert': expected string, got float64", err.Error())
+	})
+
+	t.Run("given a string where a float is expected", func(t *testing.T) {
+		err := Run(i, `alert(cpu GT high);`)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <ArgumentTypeError> arg 3 of 'alert': expected float64, got string", err.Error())
+	})
+}
+
+func TestImplementationVariadic(t *testing.T) {
+	var got []string
+
+	i := &Implementation{}
+	i.Func("tag", func(name string, values ...string) {
+		got = append([]string{name}, values...)
+	})
+
+	t.Run("the documentation shows the variadic parameter", func(t *testing.T) {
+		assert.Contains(t, i.Functions(), "tag(string ...string);")
+	})
+
+	t.Run("given extra arguments", func(t *testing.T) {
+		require.NoError(t, Run(i, `tag(a b c);`))
+
+		assert.Equal(t, []string{"a", "b", "c"}, got)
+	})
+
+	t.Run("given no variadic arguments", func(t *testing.T) {
+		require.NoError(t, Run(i, `tag(a);`))
+
+		assert.Equal(t, []string{"a"}, got)
+	})
+
+	t.Run("given too few arguments", func(t *testing.T) {
+		err := Run(i, `tag();`)
+
+		assert.EqualError(t, err, "Runtime Error: <ArgumentError> Attempting to call 'tag' with 0 arguments. Expected at least 1")
+	})
+
+	t.Run("given a variadic argument of the wrong type", func(t *testing.T) {
+		err := Run(i, `tag(a b 3);`)
+
+		assert.EqualError(t, err, "Runtime Error: <ArgumentTypeError> arg 3 of 'tag': expected string, got float64")
+	})
+}
+
+func TestImplementationFuncWithSignatureNamedArguments(t *testing.T) {
+	var alerted []string
+
+	i := &Implementation{}
+	i.FuncWithSignature("alert", []string{"metric", "operator", "value"}, func(metric string, operator string, value string) {
+		alerted = append(alerted, metric+" "+operator+" "+value)
+	})
+
+	run := func(args map[string]interface{}) error {
+		prog, err := CompileSource(`alert(input(args));`)
+
+		require.NoError(t, err)
+
+		m := NewSync(i)
+		m.SetInput(map[string]interface{}{"args": args})
+
+		return m.Execute(prog)
+	}
+
+	t.Run("given every named argument", func(t *testing.T) {
+		err := run(map[string]interface{}{"metric": "cpu", "operator": "gt", "value": "90"})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"cpu gt 90"}, alerted)
+	})
+
+	t.Run("given missing named arguments", func(t *testing.T) {
+		err := run(map[string]interface{}{"metric": "cpu"})
+
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "<ArgumentError> Attempting to call 'alert' with named arguments, missing 'operator', 'value'")
+	})
+
+	t.Run("given extra named arguments", func(t *testing.T) {
+		err := run(map[string]interface{}{"metric": "cpu", "operator": "gt", "value": "90", "window": "5m", "team": "ops"})
+
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "<ArgumentError> Attempting to call 'alert' with named arguments, unknown 'team', 'window'")
+	})
+
+	t.Run("given a function without named parameters", func(t *testing.T) {
+		i := &Implementation{}
+		i.Func("alert", func(metric string, operator string, value string) {})
+
+		prog, err := CompileSource(`alert(input(args));`)
+
+		require.NoError(t, err)
+
+		m := NewSync(i)
+		m.SetInput(map[string]interface{}{"args": map[string]interface{}{"metric": "cpu", "operator": "gt", "value": "90"}})
+
+		assert.Error(t, m.Execute(prog))
+	})
+}
+
+func TestImplementationFunctionsDetailed(t *testing.T) {
+	i := &Implementation{}
+	i.FuncWithDoc("alert", "sends an alert for the metric", func(metric string) {})
+	i.Func("warn", func(metric string) {})
+
+	docs := i.FunctionsDetailed()
+
+	assert.Contains(t, docs, FunctionDoc{Name: "alert", Signature: "alert(string);", Description: "sends an alert for the metric"})
+	assert.Contains(t, docs, FunctionDoc{Name: "warn", Signature: "warn(string);"})
+	assert.Contains(t, docs, FunctionDoc{Name: "set", Signature: "set(string) string;", Description: "returns the passed in value"})
+
+	for n := 1; n < len(docs); n++ {
+		assert.True(t, docs[n-1].Name < docs[n].Name)
+	}
+
+	t.Run("given a stdlib function name", func(t *testing.T) {
+		assert.Panics(t, func() {
+			i.FuncWithDoc("set", "", func(in string) string { return in })
+		})
+	})
+}
+
+func TestImplementationAlias(t *testing.T) {
+	var calls []string
+
+	i := &Implementation{}
+	i.Func("notify", func(team string) {
+		calls = append(calls, team)
+	})
+	i.Alias("notify", "page-team")
+
+	err := Run(i, "notify(a);\npage-team(b);")
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, calls)
+	assert.Contains(t, i.Functions(), "page-team(string);")
+
+	t.Run("errors use the alias", func(t *testing.T) {
+		err := Run(i, `page-team();`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Attempting to call 'page-team' with 0 arguments")
+	})
+
+	t.Run("given a function that doesn't exist", func(t *testing.T) {
+		assert.Panics(t, func() { i.Alias("missing", "other") })
+	})
+
+	t.Run("given a stdlib function", func(t *testing.T) {
+		assert.Panics(t, func() { i.Alias("set", "other") })
+	})
+
+	t.Run("given a stdlib alias", func(t *testing.T) {
+		assert.Panics(t, func() { i.Alias("notify", "set") })
+	})
+
+	t.Run("given an alias that already exists", func(t *testing.T) {
+		assert.Panics(t, func() { i.Alias("notify", "page-team") })
+	})
+}
+
+func TestImplementationFuncLazy(t *testing.T) {
+	var calls []string
+
+	i := &Implementation{}
+	i.Func("expensive", func(name string) string {
+		calls = append(calls, name)
+		return name
+	})
+	i.FuncLazy("or-else", []int{1}, func(primary string, fallback Thunk) (interface{}, error) {
+		if primary != "" {
+			return primary, nil
+		}
+		return fallback()
+	})
+	i.FuncLazy("twice", []int{0}, func(ctx context.Context, v Thunk) (string, error) {
+		a, err := v()
+		if err != nil {
+			return "", err
+		}
+		b, _ := v()
+		return a.(string) + b.(string), nil
+	})
+
+	t.Run("given a lazy argument that isn't evaluated", func(t *testing.T) {
+		calls = nil
+
+		v, err := execValue(t, NewSync(i), `return or-else(primary expensive(fallback));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "primary", v)
+		assert.Empty(t, calls)
+	})
+
+	t.Run("given a lazy argument that is evaluated", func(t *testing.T) {
+		calls = nil
+
+		v, err := execValue(t, NewSync(i), `return or-else("" expensive(fallback));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "fallback", v)
+		assert.Equal(t, []string{"fallback"}, calls)
+	})
+
+	t.Run("a lazy argument is only evaluated once", func(t *testing.T) {
+		calls = nil
+
+		v, err := execValue(t, NewSync(i), `return twice(expensive(a));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "aa", v)
+		assert.Equal(t, []string{"a"}, calls)
+	})
+
+	t.Run("given an error from a lazy argument", func(t *testing.T) {
+		calls = nil
+
+		_, err := execValue(t, NewSync(i), `return or-else("" fatal(boom));`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "boom")
+	})
+
+	t.Run("given a position that isn't a Thunk", func(t *testing.T) {
+		assert.Panics(t, func() {
+			(&Implementation{}).FuncLazy("bad", []int{0}, func(v string) {})
+		})
+	})
+
+	t.Run("given a position past the parameters", func(t *testing.T) {
+		assert.Panics(t, func() {
+			(&Implementation{}).FuncLazy("bad", []int{1}, func(v Thunk) {})
+		})
+	})
+}
+
+func TestImplementationSetCaseInsensitive(t *testing.T) {
+	var calls []string
+
+	build := func(insensitive bool) *Implementation {
+		i := &Implementation{}
+		i.Func("alert", func(name string) {
+			calls = append(calls, name)
+		})
+		i.SetCaseInsensitive(insensitive)
+		return i
+	}
+
+	t.Run("given the mode is on", func(t *testing.T) {
+		calls = nil
+
+		err := Run(build(true), "Alert(a);\nALERT(b);\nalert(c);\nreturn SET(d);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a", "b", "c"}, calls)
+	})
+
+	t.Run("given the mode is off", func(t *testing.T) {
+		err := Run(build(false), "Alert(a);")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "program requires unavailable functions 'Alert'")
+	})
+
+	t.Run("given functions that only differ by case", func(t *testing.T) {
+		i := build(true)
+
+		assert.Panics(t, func() { i.Func("ALERT", func() {}) })
+		assert.Panics(t, func() { i.Func("Set", func() {}) })
+	})
+
+	t.Run("given functions that only differ by case before the mode is on", func(t *testing.T) {
+		i := build(false)
+		i.Func("ALERT", func() {})
+
+		assert.Panics(t, func() { i.SetCaseInsensitive(true) })
+
+		err := Run(i, "alert(a);\nALERT();")
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestImplementationSetPrecedence(t *testing.T) {
+	var deleted []string
+
+	build := func(p Precedence) *Implementation {
+		i := &Implementation{}
+		i.Func("_delete", func(name string) {
+			deleted = append(deleted, name)
+		})
+		i.SetPrecedence(p)
+		return i
+	}
+
+	src := "const a = set(x);\n_delete(a);\nconst a = set(y);"
+
+	t.Run("given native functions first", func(t *testing.T) {
+		deleted = nil
+
+		err := Run(build(NativeFirst), src)
+
+		require.NoError(t, err)
+
+		assert.Empty(t, deleted)
+	})
+
+	t.Run("given functions first", func(t *testing.T) {
+		deleted = nil
+
+		err := Run(build(FuncFirst), src)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Attempting to reassign a value to a constant.")
+
+		assert.Equal(t, []string{"a"}, deleted)
+	})
+
+	t.Run("given functions first and a disabled native function", func(t *testing.T) {
+		deleted = nil
+
+		i := build(FuncFirst)
+		i.DisableNative("_delete")
+
+		err := Run(i, "_delete(a);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a"}, deleted)
+	})
+
+	t.Run("given functions first without a function", func(t *testing.T) {
+		i := &Implementation{}
+		i.SetPrecedence(FuncFirst)
+
+		err := Run(i, src)
+
+		assert.NoError(t, err)
+	})
+}
+
+type handlerPanicError struct {
+	recovered interface{}
+}
+
+func (e *handlerPanicError) Error() string {
+	return "handler panicked"
+}
+
+func TestImplementationSetPanicConverter(t *testing.T) {
+	newImpl := func() *Implementation {
+		i := &Implementation{}
+		i.Func("explode", func() { panic("boom") })
+
+		return i
+	}
+
+	t.Run("given no converter", func(t *testing.T) {
+		err := Run(newImpl(), "explode();")
+
+		require.Error(t, err)
+
+		rErr, ok := err.(*RuntimeError)
+		require.True(t, ok)
+		assert.Equal(t, "HandlerPanic", rErr.Code)
+		assert.Contains(t, rErr.Message, "function 'explode' panicked: boom")
+	})
+
+	t.Run("given a converter", func(t *testing.T) {
+		i := newImpl()
+		i.SetPanicConverter(func(recovered interface{}) error {
+			return &handlerPanicError{recovered: recovered}
+		})
+
+		err := Run(i, "explode();")
+
+		require.Error(t, err)
+
+		pErr, ok := err.(*handlerPanicError)
+		require.True(t, ok)
+		assert.Equal(t, "boom", pErr.recovered)
+	})
+
+	t.Run("given a converter that returns nil", func(t *testing.T) {
+		i := newImpl()
+		i.SetPanicConverter(func(recovered interface{}) error { return nil })
+
+		err := Run(i, "explode();")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "HandlerPanic")
+	})
+}
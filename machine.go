@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,18 +20,76 @@ type Machine struct {
 	count     uint64
 	lastState *machineST
 	env       map[string]string
+	transform func(*ProgramIL) (*ProgramIL, error)
+	onError   func(*ProgramIL, error) error
+	input     interface{}
+	onAssign  func(string, interface{})
+	onRead    func(string, interface{})
+	onFrame   func(int, FrameEvent)
+	onLimit   func(LimitEvent)
+
+	// The circuit breaker's settings, and the consecutive failures of each program.
+	cbThreshold int
+	cbCooldown  time.Duration
+	circuits    map[string]*circuit
+
+	// Programs can't assign variables or call native functions that change state.
+	readOnly bool
+
+	// The most arguments a function can be called with. Zero is unlimited.
+	maxArgs int
+
+	// Programs that call a function with the wrong number of arguments aren't run.
+	strictArity bool
+
+	// The most bytes the values assigned to variables can use. Zero is unlimited.
+	maxHeapBytes int
+
+	// The context of every execution. It's canceled by Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// A synchronous machine runs programs on the calling goroutine, one at a time.
+	sync  bool
+	runMu sync.Mutex
+
+	// A program is running.
+	busy bool
 }
 
 // MacC is the interface available in a running program's context.
 type MacC interface {
 	Getenv(string) string
+	LookupEnv(string) (string, bool)
+	Frames() []FrameInfo
+	StackDepth() int
+	Input() interface{}
+	ExecutionCount() uint64
+	ProgramID() []byte
 }
 
 // A machine process that is waiting to be run.
 type mProcess struct {
-	prog *ProgramIL
-	done chan interface{}
-	in   time.Time
+	prog  *ProgramIL
+	opts  execOptions
+	done  chan interface{}
+	in    time.Time
+	value interface{}
+}
+
+// How a program is run.
+type execOptions struct {
+	// The function used to lookup a function by it's name
+	lookup lookupFunc
+
+	// The program's compiled code. The program's nodes are walked when it's nil.
+	code []instruction
+
+	// A prior run's state. Its variables are constants in the run.
+	state *machineST
+
+	// The functions the program is allowed to call, by their key. Every function is allowed when it's nil.
+	allowed map[string]bool
 }
 
 // New returns a new machine.
@@ -36,10 +97,14 @@ func New(impl *Implementation) *Machine {
 	impl.mergeStdlib()
 	i := impl.dup()
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	m := &Machine{
-		impl: i,
-		exec: make(chan *mProcess),
-		env:  make(map[string]string, 0),
+		impl:   i,
+		exec:   make(chan *mProcess),
+		env:    make(map[string]string, 0),
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
 	go m.run()
@@ -47,6 +112,23 @@ func New(impl *Implementation) *Machine {
 	return m
 }
 
+// NewSync returns a new machine that runs programs on the goroutine that calls execute.
+//
+// No background goroutine is started, so the machine doesn't need to be shutdown.
+func NewSync(impl *Implementation) *Machine {
+	impl.mergeStdlib()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Machine{
+		impl:   impl.dup(),
+		env:    make(map[string]string, 0),
+		sync:   true,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
 // Getenv returns the environment variable
 func (m *Machine) Getenv(name string) string {
 	m.mu.RLock()
@@ -54,6 +136,14 @@ func (m *Machine) Getenv(name string) string {
 	return m.env[name]
 }
 
+// LookupEnv returns the environment variable, and whether it's set
+func (m *Machine) LookupEnv(name string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.env[name]
+	return v, ok
+}
+
 // Setenv sets an environment variable
 func (m *Machine) Setenv(name string, value string) {
 	m.mu.Lock()
@@ -62,8 +152,202 @@ func (m *Machine) Setenv(name string, value string) {
 	m.env[name] = value
 }
 
-// Shutdown stops the machine.
+// ExportEnv returns a copy of every environment variable.
+func (m *Machine) ExportEnv() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	env := make(map[string]string, len(m.env))
+	for k, v := range m.env {
+		env[k] = v
+	}
+	return env
+}
+
+// ImportEnv sets every environment variable from the map. If replace is true the variables that aren't in the map are
+// removed, otherwise they're kept and the map's variables are merged over them.
+func (m *Machine) ImportEnv(env map[string]string, replace bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if replace {
+		m.env = make(map[string]string, len(env))
+	}
+	for k, v := range env {
+		m.env[k] = v
+	}
+}
+
+// SetTransform sets a function that can rewrite every program before it's validated and run.
+//
+// If the function returns an error the program isn't run and the error is returned from the execute call. A nil program
+// is an InvalidTransform error.
+func (m *Machine) SetTransform(fn func(*ProgramIL) (*ProgramIL, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transform = fn
+}
+
+// SetInput sets the value that programs read with the `input` function.
+func (m *Machine) SetInput(v interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.input = v
+}
+
+// SetVarHook sets functions that are called when a variable is assigned, and when a variable is read.
+//
+// Either function can be nil.
+func (m *Machine) SetVarHook(onAssign func(name string, v interface{}), onRead func(name string, v interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onAssign = onAssign
+	m.onRead = onRead
+}
+
+// FrameEvent is a change to the stack of a running program.
+type FrameEvent int
+
+const (
+	// FramePush is a frame added to the stack.
+	FramePush FrameEvent = iota
+
+	// FramePop is a frame removed from the stack.
+	FramePop
+)
+
+func (e FrameEvent) String() string {
+	switch e {
+	case FramePush:
+		return "push"
+	case FramePop:
+		return "pop"
+	default:
+		return fmt.Sprintf("FrameEvent(%d)", int(e))
+	}
+}
+
+// SetFrameHook sets a function that's called when a frame is pushed to or popped from the stack, with the depth of
+// that frame. A frame's push and pop have the same depth. Nil removes the hook.
+//
+// The function is called on the machine's goroutine for every frame, so it should be fast.
+func (m *Machine) SetFrameHook(fn func(depth int, event FrameEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onFrame = fn
+}
+
+// LimitKind is a limit on the resources a program can use.
+type LimitKind int
+
+const (
+	// LimitHeapBytes is the most bytes the values assigned to variables can use, set with SetMaxHeapBytes.
+	LimitHeapBytes LimitKind = iota
+
+	// LimitArgs is the most arguments a function can be called with, set with SetMaxArgs.
+	LimitArgs
+
+	// LimitStackDepth is the maximum depth of the stack.
+	LimitStackDepth
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitHeapBytes:
+		return "heap bytes"
+	case LimitArgs:
+		return "args"
+	case LimitStackDepth:
+		return "stack depth"
+	default:
+		return fmt.Sprintf("LimitKind(%d)", int(k))
+	}
+}
+
+// LimitEvent is a limit that a running program exceeded.
+type LimitEvent struct {
+	// The limit that was exceeded
+	Kind LimitKind
+
+	// The limit's value
+	Limit int
+
+	// The ID of the program that exceeded it
+	ProgramID []byte
+}
+
+// SetLimitHook sets a function that's called when a program exceeds a limit, just before the limit's error is
+// returned. Nil removes the hook.
+func (m *Machine) SetLimitHook(fn func(ev LimitEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onLimit = fn
+}
+
+// SetReadOnly stops programs from assigning variables and calling native functions that change state, like `_delete`.
+//
+// A program that tries returns a ReadOnlyViolation error.
+func (m *Machine) SetReadOnly(readOnly bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.readOnly = readOnly
+}
+
+// SetMaxHeapBytes sets the most bytes the values assigned to variables can use. The size is approximate: strings are
+// their length, and slices and maps are the size of their values. An assignment that would use more returns a
+// HeapBytesExceeded error. Zero is unlimited, which is the default.
+func (m *Machine) SetMaxHeapBytes(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxHeapBytes = n
+}
+
+// SetMaxArgs sets the most arguments a function can be called with. Calling a function with more returns a
+// TooManyArguments error. Zero is unlimited, which is the default.
+func (m *Machine) SetMaxArgs(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxArgs = n
+}
+
+// SetStrictArity sets if a program that calls a function with the wrong number of arguments is rejected before it
+// runs. Every call is checked, and a function with named parameters must be passed each of them instead of a map.
+//
+// When it's off, which is the default, a call with the wrong number of arguments is an ArgumentError when it's run.
+func (m *Machine) SetStrictArity(strict bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.strictArity = strict
+}
+
+// SetErrorHandler sets a function that's called with every error from running a program.
+//
+// The error returned by the handler replaces the program's error. Returning nil makes the run successful.
+func (m *Machine) SetErrorHandler(fn func(*ProgramIL, error) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onError = fn
+}
+
+// Shutdown stops the machine. The context passed to functions that are running is canceled.
+//
+// A synchronous machine doesn't have anything to stop, but programs can't be run after it's shutdown.
 func (m *Machine) Shutdown() {
+	m.cancel()
+
+	if m.sync {
+		return
+	}
 	m.exec <- nil
 }
 
@@ -79,29 +363,375 @@ func (m *Machine) run() {
 
 // Execute runs the program in the machine.
 func (m *Machine) Execute(p *ProgramIL) error {
-	for name := range p.FuncCalls {
-		_, err := m.impl.lookup(name)
+	_, err := m.ExecuteValue(p)
+
+	return err
+}
+
+// ExecuteValue runs the program in the machine and returns the value from the program's `return` statement.
+//
+// If the program doesn't return, or returns without a value, the value is nil.
+func (m *Machine) ExecuteValue(p *ProgramIL) (interface{}, error) {
+	return m.enqueue(p, execOptions{lookup: m.impl.lookup})
+}
+
+// Decide runs the program and returns its value as a bool, like a condition. If truthiness is enabled, a string or
+// number is converted using its rules.
+//
+// A program without a value is a NoValue error, and any other value that isn't a bool is a TypeError.
+func (m *Machine) Decide(p *ProgramIL) (bool, error) {
+	v, err := m.ExecuteValue(p)
+	if err != nil {
+		return false, err
+	}
+
+	if v == nil {
+		return false, withProgramID(&RuntimeError{
+			Code:    "NoValue",
+			Message: "Attempting to decide from a program that didn't return a value",
+		}, p)
+	}
+
+	st := &machineST{truthy: m.impl.truthy}
+
+	c, err := st.condition(reflect.ValueOf(v))
+
+	return c, withProgramID(err, p)
+}
+
+// ExecuteCompiled runs the compiled program in the machine and returns the program's value, like ExecuteValue.
+func (m *Machine) ExecuteCompiled(c *CompiledProgram) (interface{}, error) {
+	return m.enqueue(c.Program, execOptions{lookup: m.impl.lookup, code: c.code})
+}
+
+// ExecuteWithState runs the program with the variables from a prior run's state, obtained with State.
+//
+// The prior run's variables are constants in the program. Assigning one fails like any other constant, and it can't be
+// deleted. The prior run's state isn't changed.
+func (m *Machine) ExecuteWithState(p *ProgramIL, state MacC) error {
+	prior, ok := state.(*machineST)
+	if !ok || prior == nil {
+		return &RuntimeError{
+			Code:    "InvalidState",
+			Message: "the state must be from a machine's State",
+		}
+	}
+
+	_, err := m.enqueue(p, execOptions{lookup: m.impl.lookup, state: prior})
+
+	return err
+}
+
+// ExecuteWithAllowed runs the program, only allowing it to call the named functions and the pure stdlib functions, like
+// `format`. Calling any other function is a FunctionNotAllowed error. Native functions, like `_delete`, aren't
+// restricted.
+func (m *Machine) ExecuteWithAllowed(p *ProgramIL, allowed []string) error {
+	set := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		set[m.impl.key(name)] = true
+	}
+
+	_, err := m.enqueue(p, execOptions{lookup: m.impl.lookup, allowed: set})
+
+	return err
+}
+
+// ExecuteAsync runs the program without blocking the caller. done is called with the result from another goroutine.
+//
+// Programs are still run one at a time, so done may be called long after ExecuteAsync returns. If the machine is
+// shutdown first, done is called with a `Shutdown` error.
+func (m *Machine) ExecuteAsync(p *ProgramIL, done func(error)) {
+	go func() {
+		err := m.Execute(p)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// ExecuteWithFuncs runs the program in the machine with extra functions that are only available to this execution.
+//
+// The extra functions can't replace a function from the machine's implementation or the stdlib.
+func (m *Machine) ExecuteWithFuncs(p *ProgramIL, extra map[string]interface{}) (err error) {
+	// The extra functions are found the same way as the machine's own.
+	i := &Implementation{
+		insensitive: m.impl.insensitive,
+		precedence:  m.impl.precedence,
+		panicConv:   m.impl.panicConv,
+	}
+
+	defer func() { // Adding an invalid handler panics. Report it as an error instead.
+		if r := recover(); r != nil {
+			err = &RuntimeError{
+				Code:    "InvalidFunc",
+				Message: fmt.Sprintf("%v", r),
+			}
+		}
+	}()
+
+	for name, handler := range extra {
+		if _, err := m.impl.lookup(name); err == nil {
+			return &RuntimeError{
+				Code:    "InvalidFunc",
+				Message: fmt.Sprintf("attempting to redefine a function with name '%s'", name),
+			}
+		}
+		i.addFunc(false, name, "", handler)
+	}
+	i.freeze()
+
+	lookup := func(name string) (*iFunc, error) {
+		if fn, err := i.lookup(name); err == nil {
+			return fn, nil
+		}
+		return m.impl.lookup(name)
+	}
+
+	_, err = m.enqueue(p, execOptions{lookup: lookup})
+
+	return err
+}
+
+// Validates and sends the program to the execution channel, waiting for it to finish.
+//
+// The program is run from its code when it has been compiled, otherwise by walking its nodes.
+func (m *Machine) enqueue(p *ProgramIL, opts execOptions) (interface{}, error) {
+	m.mu.RLock()
+	transform := m.transform
+	m.mu.RUnlock()
+
+	if transform != nil {
+		t, err := transform(p)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if t == nil {
+			return nil, withProgramID(&RuntimeError{
+				Code:    "InvalidTransform",
+				Message: "the transform returned a nil program",
+			}, p)
+		}
+
+		// The compiled code belongs to the program before it was transformed.
+		if opts.code != nil && t != p {
+			opts.code = lower(t.Entry)
+		}
+		p = t
+	}
+
+	if err := m.circuitCheck(p); err != nil {
+		return nil, withProgramID(err, p)
+	}
+
+	v, err := m.dispatch(p, opts)
+
+	m.circuitRecord(p, err)
+
+	return v, withProgramID(err, p)
+}
+
+// Runs the program on the calling goroutine for a synchronous machine, otherwise on the execution channel.
+func (m *Machine) dispatch(p *ProgramIL, opts execOptions) (interface{}, error) {
+	if err := m.preflight(p, opts.lookup); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	strict := m.strictArity
+	m.mu.RUnlock()
+
+	if strict {
+		if msgs := arityErrors(p, opts.lookup, true); len(msgs) > 0 {
+			return nil, &RuntimeError{
+				Code:    "ArgumentError",
+				Message: strings.Join(msgs, ". "),
+			}
 		}
 	}
 
+	if m.sync {
+		m.runMu.Lock()
+		defer m.runMu.Unlock()
+
+		return m.execute(p, opts)
+	}
+
 	pro := &mProcess{
 		prog: p,
+		opts: opts,
 		done: make(chan interface{}),
 		in:   time.Now(),
 	}
 
-	m.exec <- pro
+	// The run goroutine stops when the machine is shutdown, so nothing would ever receive the process.
+	select {
+	case m.exec <- pro:
+	case <-m.ctx.Done():
+		return nil, shutdownError()
+	}
 
 	out := <-pro.done
 
 	switch out := out.(type) {
 	case error:
-		return out
+		return nil, out
 	default:
+		return pro.value, nil
+	}
+}
+
+// Preflight checks that every function and native function the program calls is available in the machine.
+//
+// All of the missing functions are returned in a single RuntimeError.
+func (m *Machine) Preflight(p *ProgramIL) error {
+	return m.preflight(p, m.impl.lookup)
+}
+
+func (m *Machine) preflight(p *ProgramIL, lookup lookupFunc) error {
+	return preflight(p, m.impl, lookup)
+}
+
+// Validate checks the program against each implementation, like Preflight. The results are keyed by the index of the
+// implementation, and the error is nil when the implementation has everything the program calls.
+func Validate(p *ProgramIL, impls ...*Implementation) map[string]error {
+	results := make(map[string]error, len(impls))
+
+	for i, impl := range impls {
+		impl.mergeStdlib()
+
+		results[strconv.Itoa(i)] = preflight(p, impl, impl.lookup)
+	}
+
+	return results
+}
+
+// CompileAndValidate compiles the source, then checks every function and native function the program calls is
+// available in the implementation, and that each function is called with the number of arguments it takes.
+//
+// A syntax error is returned as it is. Every other problem is returned together in a single RuntimeError.
+func CompileAndValidate(src string, impl *Implementation) (*ProgramIL, error) {
+	p, err := CompileSource(src)
+	if err != nil {
+		return nil, err
+	}
+
+	impl.mergeStdlib()
+
+	msgs := []string{}
+	if err := preflight(p, impl, impl.lookup); err != nil {
+		rErr, ok := err.(*RuntimeError)
+		if !ok {
+			return nil, err
+		}
+		msgs = append(msgs, rErr.Message)
+	}
+
+	msgs = append(msgs, arityErrors(p, impl.lookup, false)...)
+
+	if len(msgs) > 0 {
+		return nil, &RuntimeError{
+			Code:    "InvalidProgram",
+			Message: strings.Join(msgs, ". "),
+		}
+	}
+
+	return p, nil
+}
+
+// Returns the message for every function the program calls with the wrong number of arguments.
+//
+// Unless it's strict, a function with named parameters that's called with a single argument that isn't a value is
+// skipped, because the argument may be a map of them that's only known when it's run.
+func arityErrors(p *ProgramIL, lookup lookupFunc, strict bool) []string {
+	msgs := []string{}
+
+	Walk(p.Entry, func(n *NodeIL) bool {
+		if n.Kind != NodeIL_FUNC || n.Value == nil {
+			return true
+		}
+
+		fn, err := lookup(n.Value.Str)
+		if err != nil || fn.arity(len(n.Children)) {
+			return true
+		}
+
+		if !strict && fn.params != nil && len(n.Children) == 1 && n.Children[0].Kind != NodeIL_VALUE {
+			return true
+		}
+
+		msgs = append(msgs, fn.arityErr(len(n.Children)).Message)
+
+		return true
+	})
+
+	return msgs
+}
+
+func preflight(p *ProgramIL, impl *Implementation, lookup lookupFunc) error {
+	if p.Entry == nil {
+		return &IRError{Message: "program has no entry node"}
+	}
+	if n := findCycle(p.Entry); n != nil {
+		return &IRError{Node: n, Message: "node is part of a cycle"}
+	}
+
+	funcs := map[string]bool{}
+	natives := map[string]bool{}
+
+	for name := range p.FuncCalls {
+		if _, err := lookup(name); err != nil {
+			funcs[name] = true
+		}
+	}
+
+	Walk(p.Entry, func(n *NodeIL) bool {
+		if n.Value == nil {
+			return true
+		}
+
+		switch n.Kind {
+		case NodeIL_FUNC:
+			if _, err := lookup(n.Value.Str); err != nil {
+				funcs[n.Value.Str] = true
+			}
+		case NodeIL_NAT:
+			if _, ok := nativeOverride(impl.precedence, lookup, n.Value.Str); ok {
+				break
+			}
+			if !impl.hasNative(n.Value.Str) {
+				natives[n.Value.Str] = true
+			}
+		}
+
+		return true
+	})
+
+	if len(funcs) == 0 && len(natives) == 0 {
 		return nil
 	}
+
+	list := func(set map[string]bool) string {
+		names := make([]string, 0, len(set))
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return "'" + strings.Join(names, "', '") + "'"
+	}
+
+	msgs := []string{}
+	if len(funcs) > 0 {
+		msgs = append(msgs, fmt.Sprintf("functions %s", list(funcs)))
+	}
+	if len(natives) > 0 {
+		msgs = append(msgs, fmt.Sprintf("native functions %s", list(natives)))
+	}
+
+	return &RuntimeError{
+		Code:    "Unsupported",
+		Message: fmt.Sprintf("program requires unavailable %s", strings.Join(msgs, " and ")),
+	}
 }
 
 // Actually calls the execution method
@@ -110,7 +740,9 @@ func (m *Machine) runPro(p *mProcess) {
 		runtime.Goexit()
 	}
 
-	err := m.execute(p.prog)
+	value, err := m.execute(p.prog, p.opts)
+
+	p.value = value
 
 	if err != nil {
 		p.done <- err
@@ -119,10 +751,25 @@ func (m *Machine) runPro(p *mProcess) {
 	}
 }
 
+// The error for a program that's run after the machine is shutdown.
+func shutdownError() error {
+	return &RuntimeError{
+		Code:    "Shutdown",
+		Message: "the machine has been shutdown",
+	}
+}
+
 // Performs the execution of the program
-func (m *Machine) execute(p *ProgramIL) error {
+func (m *Machine) execute(p *ProgramIL, opts execOptions) (interface{}, error) {
 	m.mu.Lock() // lock around resetting the state of the machine
 	m.lastState = nil
+	m.busy = true
+
+	defer func() {
+		m.mu.Lock()
+		m.busy = false
+		m.mu.Unlock()
+	}()
 
 	// Make a copy of the machine's environment
 	env := make(map[string]string, len(m.env))
@@ -130,24 +777,72 @@ func (m *Machine) execute(p *ProgramIL) error {
 		env[k] = v
 	}
 
+	onError := m.onError
+	input := m.input
+	onAssign, onRead := m.onAssign, m.onRead
+	onFrame := m.onFrame
+	onLimit := m.onLimit
+	readOnly := m.readOnly
+	maxArgs := m.maxArgs
+	maxHeapBytes := m.maxHeapBytes
+	count := m.count
+
 	m.mu.Unlock()
 
 	// Setup the initial state
 	s := &machineST{
-		lookup: m.impl.lookup,
-		ptr:    uintptr(0x10000000),
-		progID: p.Id,
-		heap:   make(macFrame, 0),
-		stack:  make([]macFrame, 0),
-		env:    env,
-		names:  make(map[string]uintptr, 0),
+		lookup:       opts.lookup,
+		ptr:          firstAddr,
+		progID:       p.Id,
+		execCount:    count,
+		heap:         make(macFrame, 0),
+		stack:        make([]macFrame, 0),
+		env:          env,
+		names:        make(map[string]addr, 0),
+		truthy:       m.impl.truthy,
+		interp:       m.impl.interp,
+		precedence:   m.impl.precedence,
+		panicConv:    m.impl.panicConv,
+		input:        input,
+		onAssign:     onAssign,
+		onFrame:      onFrame,
+		onLimit:      onLimit,
+		allowed:      opts.allowed,
+		key:          m.impl.key,
+		onRead:       onRead,
+		readOnly:     readOnly,
+		maxArgs:      maxArgs,
+		maxHeapBytes: maxHeapBytes,
 	}
 
-	// Setup the context
-	ctx := context.WithValue(context.Background(), macCtxCurKey, s)
+	if opts.state != nil {
+		s.seed(opts.state)
+	}
+
+	if err := m.ctx.Err(); err != nil {
+		return nil, shutdownError()
+	}
+
+	// Setup the context. It's canceled when the machine is shutdown.
+	ctx := context.WithValue(m.ctx, macCtxCurKey, s)
 
 	// Call the entry node. This will be a "ROOT" and will process all of this children.
-	_, err := p.Entry.call(ctx, s)
+	var err error
+	if opts.code != nil {
+		err = s.runCode(ctx, opts.code)
+	} else {
+		_, err = p.Entry.call(ctx, s)
+	}
+
+	// A program that ends with a group that isn't chained from, and didn't return, has the group's values as its value.
+	if err == nil && !s.returned && endsWithGroup(p.Entry) {
+		s.retVal = reflect.ValueOf(interfaces(s.lastGroup))
+	}
+
+	// Deferred calls run even when the program fails. The program's error is kept over an error from a deferred call.
+	if dErr := s.runDeferred(ctx); err == nil {
+		err = dErr
+	}
 
 	// Lock around the state
 	// Even if we got an error we still "executed" a program.
@@ -156,98 +851,515 @@ func (m *Machine) execute(p *ProgramIL) error {
 	m.lastState = s
 	m.mu.Unlock()
 
-	if err != nil {
-		return err
+	if err != nil && onError != nil {
+		err = onError(p, withProgramID(err, p))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.retVal.IsValid() {
+		return s.retVal.Interface(), nil
+	}
+
+	return nil, nil
+}
+
+// Contains the current execution state of the machine.
+type machineST struct {
+	// The function used to lookup a function by it's name
+	lookup lookupFunc
+
+	// The program ID
+	progID []byte
+
+	// The number of programs the machine ran before this one
+	execCount uint64
+
+	// The current pointer
+	ptr addr
+
+	// The heap of long lived values.
+	heap macFrame
+
+	// The stack. Every node call gets it's own stack
+	stack []macFrame
+
+	// A copy of the machine environment
+	env map[string]string
+
+	// The table of variable names and the heap pointer for that variable
+	names map[string]addr
+
+	// The variables from a prior run's state. They can't be deleted.
+	seeded map[string]bool
+
+	// Conditions can be any value instead of only a bool
+	truthy bool
+
+	// Strings have environment variables interpolated
+	interp bool
+
+	// The order a native function and a function with the same name are resolved in
+	precedence Precedence
+
+	// Converts a function handler's panic into an error
+	panicConv func(interface{}) error
+
+	// The program has returned and no more statements should run
+	returned bool
+
+	// The value the program returned
+	retVal reflect.Value
+
+	// The calls to run after the program finishes, in the order they were deferred
+	deferred []*NodeIL
+
+	// Variables can't be assigned and native functions that change state can't be called
+	readOnly bool
+
+	// The most arguments a function can be called with. Zero is unlimited.
+	maxArgs int
+
+	// The most bytes the heap's values can use, and the bytes they use now. Zero is unlimited.
+	maxHeapBytes int
+	heapBytes    int
+
+	// The return values from the last group that finished.
+	lastGroup []reflect.Value
+
+	// The grouped return values for the group's chained function. They're passed as arguments if it's variadic.
+	spread []reflect.Value
+
+	// The value set with the machine's SetInput
+	input interface{}
+
+	// Called when a variable is assigned or read
+	onAssign func(string, interface{})
+	onRead   func(string, interface{})
+
+	// Called when a frame is pushed or popped
+	onFrame func(int, FrameEvent)
+
+	// Called when a limit is exceeded
+	onLimit func(LimitEvent)
+
+	// The functions the program is allowed to call, and the implementation's key for a function's name
+	allowed map[string]bool
+	key     func(string) string
+}
+
+// Checks the function can be called in this execution.
+func (m *machineST) allow(fn *iFunc) error {
+	if m.allowed == nil || m.allowed[m.key(fn.name)] || fn.std && contains(pureFunctionNames, fn.name) {
+		return nil
+	}
+
+	return &RuntimeError{
+		Code:    "FunctionNotAllowed",
+		Message: fmt.Sprintf("Attempting to call '%s', which isn't allowed", fn.name),
+		Loc:     uint64(m.ptr),
+	}
+}
+
+// Calls the limit hook for a limit the program exceeded.
+func (m *machineST) exceeded(kind LimitKind, limit int) {
+	if m.onLimit != nil {
+		m.onLimit(LimitEvent{Kind: kind, Limit: limit, ProgramID: m.ProgramID()})
+	}
+}
+
+// Copies the variables from a prior run's state into the heap, in the order of their names.
+func (m *machineST) seed(prior *machineST) {
+	names := make([]string, 0, len(prior.names))
+	for name := range prior.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.seeded = make(map[string]bool, len(names))
+	for _, name := range names {
+		v := prior.heap[prior.names[name]]
+
+		m.names[name] = m.ptr
+		m.heap[m.ptr] = v
+		m.heapBytes += sizeOf(v)
+		m.seeded[name] = true
+		m.ptr++
+	}
+}
+
+// Pushes a new stack frame
+func (m *machineST) push() {
+	m.stack = append([]macFrame{macFrame{}}, m.stack...)
+
+	if m.onFrame != nil {
+		m.onFrame(len(m.stack), FramePush)
+	}
+}
+
+// returns a value from the stack walking up the frames as needed.
+func (m *machineST) sGet(ptr addr) (reflect.Value, bool) {
+	for _, stack := range m.stack {
+		if v, ok := stack[ptr]; ok {
+			return v, true
+		}
+	}
+	return reflect.ValueOf(nil), false
+}
+
+// set a value into the current stack frame
+func (m *machineST) sSet(ptr addr, v reflect.Value) {
+	m.stack[0][ptr] = v
+}
+
+// remove the current stack frame and return it.
+func (m *machineST) pop() macFrame {
+	current := m.stack[0]
+
+	if m.onFrame != nil {
+		m.onFrame(len(m.stack), FramePop)
+	}
+
+	m.stack = m.stack[1:]
+
+	return current
+}
+
+// Runs the deferred calls, the last deferred first. Every call is run, and the first error is returned.
+func (m *machineST) runDeferred(ctx context.Context) error {
+	var first error
+
+	for i := len(m.deferred) - 1; i >= 0; i-- {
+		if _, err := m.deferred[i].call(ctx, m); err != nil && first == nil {
+			first = err
+		}
+	}
+	m.deferred = nil
+
+	return first
+}
+
+// Allow a caller to get an env variable
+func (m *machineST) Getenv(name string) string {
+	return m.env[name]
+}
+
+// Allow a caller to get an env variable, and whether it's set
+func (m *machineST) LookupEnv(name string) (string, bool) {
+	v, ok := m.env[name]
+	return v, ok
+}
+
+func (m *machineST) readOnlyErr(msg string) error {
+	return &RuntimeError{
+		Code:    "ReadOnlyViolation",
+		Message: fmt.Sprintf("%s, but the machine is read-only", msg),
+		Loc:     uint64(m.ptr),
+	}
+}
+
+// Returns a Thunk that calls the node the first time it's called.
+func (m *machineST) thunk(ctx context.Context, n *NodeIL) Thunk {
+	var called bool
+	var value interface{}
+	var err error
+
+	return func() (interface{}, error) {
+		if called {
+			return value, err
+		}
+		called = true
+
+		var s macFrame
+		s, err = n.call(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+
+		val, ok := s[stackReturnPtr]
+		if !ok {
+			err = &RuntimeError{
+				Code:    "MissingReturnValue",
+				Message: fmt.Sprintf("no return value found for %s", renderNode(n)),
+			}
+			return nil, err
+		}
+		value = unwrap(val)
+
+		return value, nil
+	}
+}
+
+// Returns the approximate number of bytes the value uses. Strings are their length, and slices, arrays, and maps are
+// the sum of their values.
+func sizeOf(v reflect.Value) int {
+	if !v.IsValid() {
+		return 0
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return 0
+		}
+		return sizeOf(v.Elem())
+	case reflect.String:
+		return v.Len()
+	case reflect.Slice, reflect.Array:
+		size := 0
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i))
+		}
+		return size
+	case reflect.Map:
+		size := 0
+		for _, k := range v.MapKeys() {
+			size += sizeOf(k) + sizeOf(v.MapIndex(k))
+		}
+		return size
+	default:
+		return int(v.Type().Size())
+	}
+}
+
+// Returns the elements of a slice or array value.
+func (m *machineST) unpack(v reflect.Value) ([]reflect.Value, error) {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	if !v.IsValid() || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) {
+		return nil, &RuntimeError{
+			Code:    "AssignmentError",
+			Message: fmt.Sprintf("Attempting to assign more than one name from %s, expected a slice.", renderTyped(v)),
+			Loc:     uint64(m.ptr),
+		}
+	}
+
+	values := make([]reflect.Value, v.Len())
+	for i := range values {
+		values[i] = v.Index(i)
+	}
+
+	return values, nil
+}
+
+// Converts a value into a bool for a condition.
+//
+// Without truthiness enabled anything that isn't a bool is a TypeError.
+func (m *machineST) condition(v reflect.Value) (bool, error) {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	if v.IsValid() && v.Kind() == reflect.Bool {
+		return v.Bool(), nil
+	}
+
+	if m.truthy {
+		if !v.IsValid() {
+			return false, nil
+		}
+
+		switch v.Kind() {
+		case reflect.String:
+			return v.Len() > 0, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int() != 0, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v.Uint() != 0, nil
+		case reflect.Float32, reflect.Float64:
+			return v.Float() != 0, nil
+		}
+	}
+
+	kind := "nil"
+	if v.IsValid() {
+		kind = v.Type().String()
 	}
 
-	return nil
+	return false, &RuntimeError{
+		Code:    "TypeError",
+		Message: fmt.Sprintf("expected a bool condition, got %s", kind),
+		Loc:     uint64(m.ptr),
+	}
 }
 
-// Contains the current execution state of the machine.
-type machineST struct {
-	// The function used to lookup a function by it's name
-	lookup lookupFunc
-
-	// The program ID
-	progID []byte
+// Checks if the value is equal to a match case. Numbers of any type are compared by value.
+func matches(v interface{}, label *NodeIL_DValue) bool {
+	switch label.Kind {
+	case NodeIL_DValue_STR:
+		s, ok := v.(string)
+		return ok && s == label.Str
+	case NodeIL_DValue_BOOL:
+		b, ok := v.(bool)
+		return ok && b == label.Bool
+	case NodeIL_DValue_FLT:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()) == label.Flt
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(rv.Uint()) == label.Flt
+		case reflect.Float32, reflect.Float64:
+			return rv.Float() == label.Flt
+		}
+	}
+	return false
+}
 
-	// The current pointer
-	ptr uintptr
+// Returns the value held by the reflect value, or nil if there isn't one.
+func unwrap(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
 
-	// The heap of long lived values.
-	heap macFrame
+// Checks if the value is missing, or is a nil pointer or interface.
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
 
-	// The stack. Every node call gets it's own stack
-	stack []macFrame
+// Replaces every `${NAME}` in the string with the value from the lookup. `$${` is a literal `${`.
+func interpolate(str string, lookup func(string) string) string {
+	if !strings.Contains(str, "${") {
+		return str
+	}
 
-	// A copy of the machine environment
-	env map[string]string
+	b := strings.Builder{}
+
+	for len(str) > 0 {
+		switch {
+		case strings.HasPrefix(str, "$${"):
+			b.WriteString("${")
+			str = str[3:]
+		case strings.HasPrefix(str, "${"):
+			end := strings.IndexRune(str, '}')
+			if end == -1 { // Not closed. Leave the rest as is.
+				b.WriteString(str)
+				return b.String()
+			}
+			b.WriteString(lookup(str[2:end]))
+			str = str[end+1:]
+		default:
+			b.WriteByte(str[0])
+			str = str[1:]
+		}
+	}
 
-	// The table of variable names and the heap pointer for that variable
-	names map[string]uintptr
+	return b.String()
 }
 
-// Pushes a new stack frame
-func (m *machineST) push() {
-	m.stack = append([]macFrame{macFrame{}}, m.stack...)
+// Input returns the value set with the machine's SetInput.
+func (m *machineST) Input() interface{} {
+	return m.input
 }
 
-// returns a value from the stack walking up the frames as needed.
-func (m *machineST) sGet(ptr uintptr) (reflect.Value, bool) {
-	for _, stack := range m.stack {
-		if v, ok := stack[ptr]; ok {
-			return v, true
-		}
-	}
-	return reflect.ValueOf(nil), false
+// ExecutionCount returns the number of programs the machine ran before this one.
+func (m *machineST) ExecutionCount() uint64 {
+	return m.execCount
 }
 
-// set a value into the current stack frame
-func (m *machineST) sSet(ptr uintptr, v reflect.Value) {
-	m.stack[0][ptr] = v
+// ProgramID returns a copy of the running program's ID.
+func (m *machineST) ProgramID() []byte {
+	return append([]byte(nil), m.progID...)
 }
 
-// remove the current stack frame and return it.
-func (m *machineST) pop() macFrame {
-	current := m.stack[0]
+// StackDepth returns the number of frames on the stack.
+func (m *machineST) StackDepth() int {
+	return len(m.stack)
+}
 
-	m.stack = m.stack[1:]
+// Frames returns the nodes that own the current stack frames. The innermost frame is first.
+func (m *machineST) Frames() []FrameInfo {
+	frames := make([]FrameInfo, 0, len(m.stack))
+	for _, f := range m.stack {
+		if info, ok := f.info(); ok {
+			frames = append(frames, info)
+		}
+	}
+	return frames
+}
 
-	return current
+// FrameInfo describes the node that owns a stack frame.
+type FrameInfo struct {
+	Kind  NodeIL_Kind
+	Value interface{}
 }
 
-// Allow a caller to get an env variable
-func (m *machineST) Getenv(name string) string {
-	return m.env[name]
+func (f FrameInfo) String() string {
+	if f.Value == nil {
+		return f.Kind.String()
+	}
+	return fmt.Sprintf("%s %v", f.Kind.String(), f.Value)
 }
 
 // The maximum depth of the stack
 const maxStackLevel = 2000
 
-var (
+// An address in a frame or the heap. It's the same width on every platform, so the machine behaves the same on 32-bit
+// targets.
+type addr uint64
+
+// The first address allocated to a program
+const firstAddr = addr(0x10000000)
+
+// The reserved addresses are in the upper half of the address space, so they can't alias an address the machine
+// allocates counting up from the first address.
+const (
 	// The stack pointer value for the return value
-	stackReturnPtr = uintptr(0xff034680)
+	stackReturnPtr = addr(0xff034680) << 32
 
 	// The node that owns the stack frame
-	stackNodeDescPtr = uintptr(0xff00117f)
+	stackNodeDescPtr = addr(0xff00117f) << 32
 
 	// The id of the node that owns the stack frame
-	stackNodeIDPtr = uintptr(0xff001180)
+	stackNodeIDPtr = addr(0xff001180) << 32
 )
 
 // A single frame
-type macFrame map[uintptr]reflect.Value
+type macFrame map[addr]reflect.Value
 
-// executes a single node and it's children
-func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
+// returns the information for the node that owns the frame
+func (f macFrame) info() (FrameInfo, bool) {
+	v, ok := f[stackNodeDescPtr]
+	if !ok {
+		return FrameInfo{}, false
+	}
+	n, ok := v.Interface().(*NodeIL)
+	if !ok {
+		return FrameInfo{}, false
+	}
+
+	info := FrameInfo{Kind: n.Kind}
+	if n.Value != nil {
+		info.Value = n.Value.value().Interface()
+	}
+	return info, true
+}
+
+// Starts a new stack frame owned by the node.
+func (m *machineST) enter(n *NodeIL) error {
 	m.push() // Start a new stack
 
 	// Checking the stack level.
 	if len(m.stack) > maxStackLevel {
-		return macFrame{}, &RuntimeError{
+		m.exceeded(LimitStackDepth, maxStackLevel)
+		return &RuntimeError{
 			Code:    "StackLevelTooDeep",
 			Message: "maximum stack size exceeded",
-			Loc:     m.ptr,
+			Loc:     uint64(m.ptr),
 		}
 	}
 
@@ -258,6 +1370,33 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 	// Increase the execution pointer
 	m.ptr++
 
+	return nil
+}
+
+// Returns the value of a VALUE node, with environment variables interpolated when it's enabled.
+func (m *machineST) value(n *NodeIL) reflect.Value {
+	if m.interp && n.Value.Kind == NodeIL_DValue_STR {
+		return reflect.ValueOf(interpolate(n.Value.Str, m.Getenv))
+	}
+	return n.Value.value()
+}
+
+// executes a single node and it's children
+func (n *NodeIL) call(ctx context.Context, m *machineST) (frame macFrame, err error) {
+	// The first frame to see a runtime error records the stack trace. The returned frame has already been popped.
+	defer func() {
+		if e, ok := err.(*RuntimeError); ok && e.Frames == nil {
+			e.Frames = m.Frames()
+			if info, ok := frame.info(); ok {
+				e.Frames = append([]FrameInfo{info}, e.Frames...)
+			}
+		}
+	}()
+
+	if err := m.enter(n); err != nil {
+		return macFrame{}, err
+	}
+
 	switch n.Kind {
 	case NodeIL_ROOT: // Root node executes all of it's children
 		for _, c := range n.Children {
@@ -265,13 +1404,51 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			if err != nil {
 				return m.pop(), err
 			}
+			if m.returned {
+				break
+			}
+		}
+		return m.pop(), nil
+	case NodeIL_RETURN: // Stops running statements, and sets the program value from the chained call.
+		if n.Chained != nil {
+			s, err := n.Chained.call(ctx, m)
+			if err != nil {
+				return m.pop(), err
+			}
+
+			if r, ok := s[stackReturnPtr]; ok {
+				m.retVal = r
+			}
 		}
+
+		m.returned = true
+
+		return m.pop(), nil
+	case NodeIL_DEFER: // Saves the chained call to run after the program.
+		m.deferred = append(m.deferred, n.Chained)
+
 		return m.pop(), nil
 	case NodeIL_VALUE: // Sets the value to the return pointer and returns.
-		m.sSet(stackReturnPtr, n.Value.value())
+		m.sSet(stackReturnPtr, m.value(n))
 
 		return m.pop(), nil
 	case NodeIL_NAT:
+		// The implementation's function with the same name is called instead, if the precedence allows it.
+		if _, ok := nativeOverride(m.precedence, m.lookup, n.Value.Str); ok {
+			s, err := (&NodeIL{Id: n.Id, Kind: NodeIL_FUNC, Value: n.Value, Children: n.Children}).call(ctx, m)
+			if err != nil {
+				return m.pop(), err
+			}
+			if r, ok := s[stackReturnPtr]; ok {
+				m.sSet(stackReturnPtr, r)
+			}
+			return m.pop(), nil
+		}
+
+		if m.readOnly && contains(mutatingNativeNames, n.Value.Str) {
+			return m.pop(), m.readOnlyErr(fmt.Sprintf("Func %s changes state", n.Value.Str))
+		}
+
 		switch n.Value.Str {
 		case "_delete":
 			if len(n.Children) != 1 {
@@ -288,6 +1465,17 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 
 			if r, ok := s[stackReturnPtr]; ok {
 				if n, ok := r.Interface().(string); ok {
+					if m.seeded[n] {
+						return m.pop(), &RuntimeError{
+							Code:    "AssignmentError",
+							Message: fmt.Sprintf("Attempting to delete '%s', which is from a prior run's state.", n),
+							Loc:     uint64(m.ptr),
+						}
+					}
+					if ptr, ok := m.names[n]; ok {
+						m.heapBytes -= sizeOf(m.heap[ptr])
+						delete(m.heap, ptr)
+					}
 					delete(m.names, n)
 				}
 			}
@@ -300,15 +1488,41 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			}
 		}
 	case NodeIL_FUNC: // Calls the function, and it's children
-		// Make sure the function exists before doing more work.
+		// Values from a group this function is chained from.
+		spread := m.spread
+		m.spread = nil
+
+		// Make sure the function exists, and can be called, before doing more work.
 		fn, err := m.lookup(n.Value.Str)
 		if err != nil {
 			return m.pop(), err
 		}
+		if err := m.allow(fn); err != nil {
+			return m.pop(), err
+		}
+
+		// Check the number of arguments before collecting them. Only a variadic function is passed the group's values.
+		count := len(n.Children)
+		if fn.tp.IsVariadic() {
+			count += len(spread)
+		}
+		if m.maxArgs > 0 && count > m.maxArgs {
+			m.exceeded(LimitArgs, m.maxArgs)
+			return m.pop(), &RuntimeError{
+				Code:    "TooManyArguments",
+				Message: fmt.Sprintf("Attempting to call '%s' with %d arguments. The maximum is %d", fn.name, count, m.maxArgs),
+				Loc:     uint64(m.ptr),
+			}
+		}
 
 		// Call for each child. The return values will be the arguments.
 		args := []reflect.Value{}
-		for _, c := range n.Children {
+		for i, c := range n.Children {
+			if fn.lazy[i] {
+				args = append(args, reflect.ValueOf(m.thunk(ctx, c)))
+				continue
+			}
+
 			s, err := c.call(ctx, m)
 			if err != nil {
 				return m.pop(), err
@@ -318,15 +1532,19 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			if !ret {
 				return m.pop(), &RuntimeError{
 					Code:    "MissingReturnValue",
-					Message: fmt.Sprintf("no return value found for %s", c),
+					Message: fmt.Sprintf("no return value found for %s", renderNode(c)),
 				}
 			}
 
 			args = append(args, val)
 		}
 
+		if fn.tp.IsVariadic() {
+			args = append(args, spread...)
+		}
+
 		// Call the function passing in the arguments
-		ret, err := fn.call(ctx, args)
+		ret, err := fn.call(ctx, args, m.panicConv)
 		if err != nil {
 			return m.pop(), err
 		}
@@ -335,6 +1553,15 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 		if fn.retC != 0 {
 			m.sSet(stackReturnPtr, ret)
 			if n.Chained != nil {
+				// A nil pointer or interface can't be used as the chained call's last return.
+				if isNil(ret) {
+					return m.pop(), &RuntimeError{
+						Code:    "ChainingFromNil",
+						Message: fmt.Sprintf("Attempting to chain from '%s' but it returned nil", fn.name),
+						Loc:     uint64(m.ptr),
+					}
+				}
+
 				s, err := n.Chained.call(context.WithValue(ctx, macCtxRetKey, ret), m)
 				if err != nil {
 					return m.pop(), err
@@ -374,9 +1601,16 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			}
 		}
 
+		m.lastGroup = grouped
+
 		if n.Chained != nil {
+			// A variadic chained function also gets each grouped return value as an argument.
+			if n.Chained.Kind == NodeIL_FUNC {
+				m.spread = grouped
+			}
+
 			// Call the chained function passing in the slice of grouped return values as the `LastReturn`
-			s, err := n.Chained.call(context.WithValue(ctx, macCtxRetKey, reflect.ValueOf(grouped)), m)
+			s, err := n.Chained.call(context.WithValue(ctx, macCtxRetKey, groupResults(grouped)), m)
 			if err != nil {
 				return m.pop(), err
 			}
@@ -391,6 +1625,9 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 		return m.pop(), nil
 	case NodeIL_ASSIGN:
 		name := n.Value.Str
+		if m.readOnly {
+			return m.pop(), m.readOnlyErr(fmt.Sprintf("Attempting to assign '%s'", name))
+		}
 		if name == "" { // Ensure we have a valid name.
 			return m.pop(), &RuntimeError{
 				Code:    "AssignmentError",
@@ -410,10 +1647,15 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			}
 		}
 
-		if _, ok := m.names[name]; ok {
-			return m.pop(), &RuntimeError{
-				Code:    "AssignmentError",
-				Message: "Attempting to reassign a value to a constant.",
+		// More than one name is joined by commas. The value is unpacked into each name.
+		names := strings.Split(name, ",")
+
+		for _, name := range names {
+			if _, ok := m.names[name]; ok {
+				return m.pop(), &RuntimeError{
+					Code:    "AssignmentError",
+					Message: "Attempting to reassign a value to a constant.",
+				}
 			}
 		}
 
@@ -424,17 +1666,193 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 		}
 
 		ret, ok := s[stackReturnPtr]
-		if !ok {
+
+		// A group that isn't chained from doesn't return a value, but its results can be assigned to more than one name.
+		grouped := len(names) > 1 && n.Chained.Kind == NodeIL_GROUP && n.Chained.Chained == nil
+
+		if !ok && !grouped {
 			return m.pop(), &RuntimeError{
 				Code:    "AssignmentError",
 				Message: "Attempting to assign to but assignment RHS expression did not return a value.",
 			}
 		}
 
-		// Store the variable name in the names
-		m.names[name] = m.ptr
-		// Store the variable value in the heap
-		m.heap[m.ptr] = ret
+		values := []reflect.Value{ret}
+		if grouped {
+			values = m.lastGroup
+		} else if len(names) > 1 {
+			values, err = m.unpack(ret)
+			if err != nil {
+				return m.pop(), err
+			}
+		}
+
+		if len(values) != len(names) {
+			return m.pop(), &RuntimeError{
+				Code:    "AssignmentError",
+				Message: fmt.Sprintf("Attempting to assign %d names from %d values.", len(names), len(values)),
+				Loc:     uint64(m.ptr),
+			}
+		}
+
+		size := 0
+		for _, v := range values {
+			size += sizeOf(v)
+		}
+		if m.maxHeapBytes > 0 && m.heapBytes+size > m.maxHeapBytes {
+			m.exceeded(LimitHeapBytes, m.maxHeapBytes)
+			return m.pop(), &RuntimeError{
+				Code:    "HeapBytesExceeded",
+				Message: fmt.Sprintf("Attempting to assign %d bytes to '%s', but the heap is limited to %d bytes and uses %d", size, strings.Join(names, ", "), m.maxHeapBytes, m.heapBytes),
+				Loc:     uint64(m.ptr),
+			}
+		}
+		m.heapBytes += size
+
+		for i, name := range names {
+			if i > 0 { // Every variable needs its own place in the heap.
+				m.ptr++
+			}
+
+			// Store the variable name in the names
+			m.names[name] = m.ptr
+			// Store the variable value in the heap
+			m.heap[m.ptr] = values[i]
+
+			if m.onAssign != nil {
+				m.onAssign(name, unwrap(values[i]))
+			}
+		}
+
+		// The assigned value is returned so an assignment can be used as an argument.
+		if ok {
+			m.sSet(stackReturnPtr, ret)
+		}
+
+		return m.pop(), nil
+	case NodeIL_TERNARY: // Calls the condition, then only the branch it picks.
+		if len(n.Children) != 3 {
+			return m.pop(), &RuntimeError{
+				Code:    "TernaryError",
+				Message: fmt.Sprintf("expected a condition and two values, got %d children", len(n.Children)),
+				Loc:     uint64(m.ptr),
+			}
+		}
+
+		s, err := n.Children[0].call(ctx, m)
+		if err != nil {
+			return m.pop(), err
+		}
+
+		ok, err := m.condition(s[stackReturnPtr])
+		if err != nil {
+			return m.pop(), err
+		}
+
+		branch := n.Children[2]
+		if ok {
+			branch = n.Children[1]
+		}
+
+		s, err = branch.call(ctx, m)
+		if err != nil {
+			return m.pop(), err
+		}
+
+		if r, ok := s[stackReturnPtr]; ok {
+			m.sSet(stackReturnPtr, r)
+		}
+
+		return m.pop(), nil
+	case NodeIL_BINARY: // Calls both values, then applies the operator to them.
+		if len(n.Children) != 2 {
+			return m.pop(), &RuntimeError{
+				Code:    "OperatorError",
+				Message: fmt.Sprintf("expected two values, got %d children", len(n.Children)),
+				Loc:     uint64(m.ptr),
+			}
+		}
+
+		values := make([]reflect.Value, 2)
+		for i, c := range n.Children {
+			s, err := c.call(ctx, m)
+			if err != nil {
+				return m.pop(), err
+			}
+
+			v, ok := s[stackReturnPtr]
+			if !ok {
+				return m.pop(), &RuntimeError{
+					Code:    "OperatorError",
+					Message: fmt.Sprintf("Attempting to use '%s' but a value did not return anything", n.Value.Str),
+					Loc:     uint64(m.ptr),
+				}
+			}
+			values[i] = v
+		}
+
+		var ret reflect.Value
+		var err error
+		switch n.Value.Str {
+		case "+":
+			ret, err = add(values[0], values[1])
+		default:
+			err = &RuntimeError{
+				Code:    "OperatorError",
+				Message: fmt.Sprintf("unknown operator '%s'", n.Value.Str),
+			}
+		}
+		if err != nil {
+			if rErr, ok := err.(*RuntimeError); ok {
+				rErr.Loc = uint64(m.ptr)
+			}
+			return m.pop(), err
+		}
+
+		m.sSet(stackReturnPtr, ret)
+
+		return m.pop(), nil
+	case NodeIL_MATCH: // Runs the first case equal to the value, or the default case.
+		if len(n.Children) == 0 {
+			return m.pop(), &RuntimeError{
+				Code:    "MatchError",
+				Message: "Attempting to match without a value",
+				Loc:     uint64(m.ptr),
+			}
+		}
+
+		s, err := n.Children[0].call(ctx, m)
+		if err != nil {
+			return m.pop(), err
+		}
+
+		subject := unwrap(s[stackReturnPtr])
+
+		var picked *NodeIL
+		for _, c := range n.Children[1:] {
+			if c.SubType == "default" {
+				if picked == nil {
+					picked = c
+				}
+				continue
+			}
+			if c.Value != nil && matches(subject, c.Value) {
+				picked = c
+				break
+			}
+		}
+
+		if picked != nil {
+			for _, c := range picked.Children {
+				_, err := c.call(ctx, m)
+				if err != nil {
+					return m.pop(), err
+				}
+				if m.returned {
+					break
+				}
+			}
+		}
 
 		return m.pop(), nil
 	case NodeIL_VAR:
@@ -462,6 +1880,10 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 			}
 		}
 
+		if m.onRead != nil {
+			m.onRead(name, unwrap(val))
+		}
+
 		m.sSet(stackReturnPtr, val)
 
 		return m.pop(), nil
@@ -469,11 +1891,19 @@ func (n *NodeIL) call(ctx context.Context, m *machineST) (macFrame, error) {
 		return m.pop(), &RuntimeError{
 			Code:    "UnknownInstruction",
 			Message: fmt.Sprintf("Machine is not capable of executing %s", n.Kind.String()),
-			Loc:     m.ptr,
+			Loc:     uint64(m.ptr),
 		}
 	}
 }
 
+// IsBusy checks if the machine is running a program.
+func (m *Machine) IsBusy() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.busy
+}
+
 // State returns the last state of the machine.
 func (m *Machine) State() MacC {
 	m.mu.RLock()
@@ -487,13 +1917,42 @@ func (m *Machine) State() MacC {
 type RuntimeError struct {
 	Code    string
 	Message string
-	Loc     uintptr
+	Loc     uint64
+
+	// The stack trace from where the error was raised. The innermost frame is first.
+	Frames []FrameInfo
+
+	// The ID of the program that was running when the error was raised.
+	ProgramID []byte
+}
+
+// Sets the program ID on a RuntimeError that doesn't have one.
+func withProgramID(err error, p *ProgramIL) error {
+	if e, ok := err.(*RuntimeError); ok && e.ProgramID == nil {
+		e.ProgramID = p.Id
+	}
+	return err
 }
 
 func (e RuntimeError) Error() string {
 	return fmt.Sprintf("Runtime Error: <%s> %s", e.Code, e.Message)
 }
 
+// RetryableError marks an error returned by a function handler as temporary, so the program may succeed if it's run
+// again. The machine doesn't retry the program itself; the error is returned from Execute unchanged.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("Retryable Error: %s", e.Err.Error())
+}
+
+// Unwrap returns the handler's error.
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
 type macCKeyType uint32
 
 var (
@@ -510,11 +1969,48 @@ func Mac(ctx context.Context) MacC {
 	return nil
 }
 
+// The return values from a group, passed to the group's chained function.
+type groupResults []reflect.Value
+
 // LastReturn gets the last returned value from the context
+//
+// When the function is chained from a group, the value is a `[]interface{}` of the group's return values.
 func LastReturn(ctx context.Context) interface{} {
+	if values, ok := GroupResults(ctx); ok {
+		return values
+	}
 	return ctx.Value(macCtxRetKey)
 }
 
+// GroupResults gets the return values from the group a function is chained from. The bool is false when the function
+// isn't chained from a group.
+func GroupResults(ctx context.Context) ([]interface{}, bool) {
+	grouped, ok := ctx.Value(macCtxRetKey).(groupResults)
+	if !ok {
+		return nil, false
+	}
+
+	return interfaces(grouped), true
+}
+
+// Returns the unwrapped values.
+func interfaces(values []reflect.Value) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = unwrap(v)
+	}
+	return out
+}
+
+// Checks if the last statement of the root is a group that isn't chained from.
+func endsWithGroup(root *NodeIL) bool {
+	if len(root.Children) == 0 {
+		return false
+	}
+	last := root.Children[len(root.Children)-1]
+	return last.Kind == NodeIL_GROUP && last.Chained == nil
+}
+
 // Run takes raw source and compiles it and runs in a new machine.
 func Run(i *Implementation, src string) error {
 	p, err := CompileSource(src)
@@ -522,8 +2018,5 @@ func Run(i *Implementation, src string) error {
 		return err
 	}
 
-	m := New(i)
-	defer m.Shutdown()
-
-	return m.Execute(p)
+	return NewSync(i).Execute(p)
 }
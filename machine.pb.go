@@ -23,39 +23,57 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type TokenIL_Kind int32
 
 const (
-	TokenIL_NONE   TokenIL_Kind = 0
-	TokenIL_VALUE  TokenIL_Kind = 1
-	TokenIL_OPEN   TokenIL_Kind = 2
-	TokenIL_CLOSE  TokenIL_Kind = 3
-	TokenIL_END    TokenIL_Kind = 4
-	TokenIL_DOT    TokenIL_Kind = 5
-	TokenIL_PIPE   TokenIL_Kind = 6
-	TokenIL_ASSIGN TokenIL_Kind = 7
-	TokenIL_VAR    TokenIL_Kind = 8
+	TokenIL_NONE     TokenIL_Kind = 0
+	TokenIL_VALUE    TokenIL_Kind = 1
+	TokenIL_OPEN     TokenIL_Kind = 2
+	TokenIL_CLOSE    TokenIL_Kind = 3
+	TokenIL_END      TokenIL_Kind = 4
+	TokenIL_DOT      TokenIL_Kind = 5
+	TokenIL_PIPE     TokenIL_Kind = 6
+	TokenIL_ASSIGN   TokenIL_Kind = 7
+	TokenIL_VAR      TokenIL_Kind = 8
+	TokenIL_STRING   TokenIL_Kind = 9
+	TokenIL_QUESTION TokenIL_Kind = 10
+	TokenIL_COLON    TokenIL_Kind = 11
+	TokenIL_LBRACE   TokenIL_Kind = 12
+	TokenIL_RBRACE   TokenIL_Kind = 13
+	TokenIL_PLUS     TokenIL_Kind = 14
 )
 
 var TokenIL_Kind_name = map[int32]string{
-	0: "NONE",
-	1: "VALUE",
-	2: "OPEN",
-	3: "CLOSE",
-	4: "END",
-	5: "DOT",
-	6: "PIPE",
-	7: "ASSIGN",
-	8: "VAR",
+	0:  "NONE",
+	1:  "VALUE",
+	2:  "OPEN",
+	3:  "CLOSE",
+	4:  "END",
+	5:  "DOT",
+	6:  "PIPE",
+	7:  "ASSIGN",
+	8:  "VAR",
+	9:  "STRING",
+	10: "QUESTION",
+	11: "COLON",
+	12: "LBRACE",
+	13: "RBRACE",
+	14: "PLUS",
 }
 
 var TokenIL_Kind_value = map[string]int32{
-	"NONE":   0,
-	"VALUE":  1,
-	"OPEN":   2,
-	"CLOSE":  3,
-	"END":    4,
-	"DOT":    5,
-	"PIPE":   6,
-	"ASSIGN": 7,
-	"VAR":    8,
+	"NONE":     0,
+	"VALUE":    1,
+	"OPEN":     2,
+	"CLOSE":    3,
+	"END":      4,
+	"DOT":      5,
+	"PIPE":     6,
+	"ASSIGN":   7,
+	"VAR":      8,
+	"STRING":   9,
+	"QUESTION": 10,
+	"COLON":    11,
+	"LBRACE":   12,
+	"RBRACE":   13,
+	"PLUS":     14,
 }
 
 func (x TokenIL_Kind) String() string {
@@ -69,36 +87,54 @@ func (TokenIL_Kind) EnumDescriptor() ([]byte, []int) {
 type NodeIL_Kind int32
 
 const (
-	NodeIL_NONE   NodeIL_Kind = 0
-	NodeIL_ROOT   NodeIL_Kind = 1
-	NodeIL_GROUP  NodeIL_Kind = 2
-	NodeIL_FUNC   NodeIL_Kind = 3
-	NodeIL_VALUE  NodeIL_Kind = 4
-	NodeIL_ASSIGN NodeIL_Kind = 5
-	NodeIL_VAR    NodeIL_Kind = 6
-	NodeIL_NAT    NodeIL_Kind = 7
+	NodeIL_NONE    NodeIL_Kind = 0
+	NodeIL_ROOT    NodeIL_Kind = 1
+	NodeIL_GROUP   NodeIL_Kind = 2
+	NodeIL_FUNC    NodeIL_Kind = 3
+	NodeIL_VALUE   NodeIL_Kind = 4
+	NodeIL_ASSIGN  NodeIL_Kind = 5
+	NodeIL_VAR     NodeIL_Kind = 6
+	NodeIL_NAT     NodeIL_Kind = 7
+	NodeIL_RETURN  NodeIL_Kind = 8
+	NodeIL_TERNARY NodeIL_Kind = 9
+	NodeIL_MATCH   NodeIL_Kind = 10
+	NodeIL_CASE    NodeIL_Kind = 11
+	NodeIL_BINARY  NodeIL_Kind = 12
+	NodeIL_DEFER   NodeIL_Kind = 13
 )
 
 var NodeIL_Kind_name = map[int32]string{
-	0: "NONE",
-	1: "ROOT",
-	2: "GROUP",
-	3: "FUNC",
-	4: "VALUE",
-	5: "ASSIGN",
-	6: "VAR",
-	7: "NAT",
+	0:  "NONE",
+	1:  "ROOT",
+	2:  "GROUP",
+	3:  "FUNC",
+	4:  "VALUE",
+	5:  "ASSIGN",
+	6:  "VAR",
+	7:  "NAT",
+	8:  "RETURN",
+	9:  "TERNARY",
+	10: "MATCH",
+	11: "CASE",
+	12: "BINARY",
+	13: "DEFER",
 }
 
 var NodeIL_Kind_value = map[string]int32{
-	"NONE":   0,
-	"ROOT":   1,
-	"GROUP":  2,
-	"FUNC":   3,
-	"VALUE":  4,
-	"ASSIGN": 5,
-	"VAR":    6,
-	"NAT":    7,
+	"NONE":    0,
+	"ROOT":    1,
+	"GROUP":   2,
+	"FUNC":    3,
+	"VALUE":   4,
+	"ASSIGN":  5,
+	"VAR":     6,
+	"NAT":     7,
+	"RETURN":  8,
+	"TERNARY": 9,
+	"MATCH":   10,
+	"CASE":    11,
+	"BINARY":  12,
+	"DEFER":   13,
 }
 
 func (x NodeIL_Kind) String() string {
@@ -347,6 +383,7 @@ type ProgramIL struct {
 	Source               string            `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
 	Entry                *NodeIL           `protobuf:"bytes,3,opt,name=entry,proto3" json:"entry,omitempty"`
 	FuncCalls            map[string]uint64 `protobuf:"bytes,4,rep,name=func_calls,json=funcCalls,proto3" json:"func_calls,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	SourceHash           []byte            `protobuf:"bytes,5,opt,name=source_hash,json=sourceHash,proto3" json:"source_hash,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
 	XXX_unrecognized     []byte            `json:"-"`
 	XXX_sizecache        int32             `json:"-"`
@@ -405,6 +442,13 @@ func (m *ProgramIL) GetFuncCalls() map[string]uint64 {
 	return nil
 }
 
+func (m *ProgramIL) GetSourceHash() []byte {
+	if m != nil {
+		return m.SourceHash
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("machine.TokenIL_Kind", TokenIL_Kind_name, TokenIL_Kind_value)
 	proto.RegisterEnum("machine.NodeIL_Kind", NodeIL_Kind_name, NodeIL_Kind_value)
@@ -419,39 +463,46 @@ func init() {
 func init() { proto.RegisterFile("machine.proto", fileDescriptor_4b4e4a03b74bd47d) }
 
 var fileDescriptor_4b4e4a03b74bd47d = []byte{
-	// 530 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x93, 0xdf, 0x8e, 0xd2, 0x40,
-	0x14, 0xc6, 0xb7, 0xff, 0xdb, 0xa3, 0xbb, 0x4e, 0x26, 0x2b, 0xa9, 0xeb, 0x0d, 0x36, 0x31, 0x61,
-	0xa3, 0xc1, 0x64, 0xbd, 0x31, 0xc6, 0x0b, 0x11, 0xca, 0x86, 0xd8, 0xb4, 0x64, 0x28, 0xc4, 0xbb,
-	0x4d, 0x69, 0x07, 0x69, 0x28, 0x53, 0x52, 0xa8, 0x09, 0x0f, 0xe2, 0xa3, 0x79, 0xa1, 0x4f, 0x63,
-	0x66, 0xda, 0x22, 0xac, 0xdc, 0x7d, 0xe7, 0x9c, 0xaf, 0xc3, 0x7c, 0xe7, 0xc7, 0xc0, 0xe5, 0x3a,
-	0x8a, 0x97, 0x29, 0xa3, 0xdd, 0x4d, 0x91, 0xef, 0x72, 0x6c, 0xd4, 0xa5, 0xf3, 0x5b, 0x02, 0x23,
-	0xcc, 0x57, 0x94, 0x8d, 0x3c, 0x7c, 0x0b, 0xea, 0x2a, 0x65, 0x89, 0x2d, 0xb5, 0xa5, 0xce, 0xd5,
-	0xdd, 0xf3, 0x6e, 0xf3, 0x49, 0x3d, 0xef, 0x7e, 0x4d, 0x59, 0x42, 0x84, 0x05, 0x5f, 0x83, 0xf6,
-	0x23, 0xca, 0x4a, 0x6a, 0xcb, 0x6d, 0xa9, 0x63, 0x91, 0xaa, 0xc0, 0x18, 0xd4, 0x2c, 0x65, 0xd4,
-	0x56, 0xda, 0x52, 0xe7, 0x92, 0x08, 0x8d, 0x5b, 0xa0, 0xc7, 0x79, 0x56, 0xae, 0x99, 0xad, 0x8a,
-	0x6e, 0x5d, 0x39, 0x11, 0xa8, 0xfc, 0x3c, 0x6c, 0x82, 0xea, 0x07, 0xbe, 0x8b, 0x2e, 0xb0, 0x05,
-	0xda, 0xac, 0xe7, 0x4d, 0x5d, 0x24, 0xf1, 0x66, 0x30, 0x76, 0x7d, 0x24, 0xf3, 0x66, 0xdf, 0x0b,
-	0x26, 0x2e, 0x52, 0xb0, 0x01, 0x8a, 0xeb, 0x0f, 0x90, 0xca, 0xc5, 0x20, 0x08, 0x91, 0xc6, 0x6d,
-	0xe3, 0xd1, 0xd8, 0x45, 0x3a, 0x06, 0xd0, 0x7b, 0x93, 0xc9, 0xe8, 0xde, 0x47, 0x06, 0x1f, 0xcf,
-	0x7a, 0x04, 0x99, 0xce, 0x2f, 0x05, 0x74, 0x3f, 0x4f, 0xe8, 0xc8, 0xc3, 0x57, 0x20, 0xa7, 0x55,
-	0xb0, 0xa7, 0x44, 0x4e, 0x13, 0xdc, 0xa9, 0xa3, 0xca, 0x22, 0xea, 0xf5, 0x21, 0x6a, 0x65, 0x3f,
-	0x4e, 0xfa, 0x06, 0xcc, 0x78, 0x99, 0x66, 0x49, 0x41, 0x99, 0xad, 0xb4, 0x95, 0xce, 0x93, 0xbb,
-	0x67, 0x8f, 0xdc, 0xe4, 0x60, 0xc0, 0xb7, 0x60, 0xc4, 0xcb, 0x28, 0x65, 0x34, 0x11, 0x69, 0xcf,
-	0x78, 0x9b, 0x39, 0x7e, 0xdb, 0x6c, 0x50, 0x13, 0xc6, 0xd6, 0xe3, 0x2b, 0x0c, 0x66, 0x7c, 0xda,
-	0x6c, 0xf6, 0x05, 0x98, 0xdb, 0x72, 0xfe, 0xb0, 0xdb, 0x6f, 0xa8, 0xad, 0x8b, 0x95, 0x1b, 0xdb,
-	0x72, 0x1e, 0xee, 0x37, 0xf4, 0xe6, 0xa7, 0x04, 0x7a, 0x65, 0xc6, 0xef, 0x4e, 0x00, 0xbe, 0x3c,
-	0x7f, 0xe4, 0x71, 0x38, 0x04, 0xca, 0x76, 0x57, 0xd4, 0x10, 0xb9, 0xe4, 0x9d, 0x45, 0xb6, 0x13,
-	0x04, 0x25, 0xc2, 0x25, 0x87, 0x3a, 0xcf, 0xf3, 0x4c, 0x04, 0x32, 0x89, 0xd0, 0x8e, 0x53, 0xc3,
-	0x33, 0x40, 0x99, 0x84, 0x04, 0x5d, 0x70, 0x31, 0xf4, 0xc2, 0x8a, 0xdc, 0x97, 0x20, 0xf0, 0x90,
-	0xec, 0x7c, 0xfb, 0x0f, 0xb0, 0x09, 0x2a, 0x09, 0x02, 0xee, 0xb2, 0x40, 0xbb, 0x27, 0xc1, 0x74,
-	0x8c, 0x64, 0xde, 0x1c, 0x4e, 0xfd, 0x3e, 0x52, 0xfe, 0xf1, 0x57, 0x8f, 0x70, 0x6a, 0x0d, 0x4e,
-	0x9d, 0x0b, 0xbf, 0x17, 0x22, 0xc3, 0xf9, 0x23, 0x81, 0x35, 0x2e, 0xf2, 0xef, 0x45, 0xb4, 0x3e,
-	0x83, 0xb6, 0x05, 0xfa, 0x36, 0x2f, 0x8b, 0xb8, 0xf9, 0x6f, 0xd6, 0x15, 0x7e, 0x0d, 0x1a, 0x65,
-	0xbb, 0x62, 0x2f, 0xb2, 0x9d, 0x21, 0x53, 0x4d, 0xf1, 0x67, 0x80, 0x45, 0xc9, 0xe2, 0x87, 0x38,
-	0xca, 0xb2, 0xad, 0xad, 0x0a, 0xe2, 0xaf, 0x0e, 0xde, 0xc3, 0xcf, 0x76, 0x87, 0x25, 0x8b, 0xfb,
-	0xdc, 0xe3, 0xf2, 0xcf, 0x88, 0xb5, 0x68, 0xea, 0x9b, 0x4f, 0x70, 0x75, 0x3a, 0xe4, 0x4b, 0x5d,
-	0xd1, 0xbd, 0xb8, 0xa3, 0x45, 0xb8, 0x3c, 0x7d, 0x3f, 0x6a, 0x4d, 0xf9, 0xa3, 0xfc, 0x41, 0x9a,
-	0xeb, 0xe2, 0x81, 0xbe, 0xff, 0x1b, 0x00, 0x00, 0xff, 0xff, 0xc5, 0xb2, 0x1a, 0x7a, 0xb1, 0x03,
-	0x00, 0x00,
+	// 644 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x94, 0x4d, 0x6f, 0xd3, 0x4c,
+	0x10, 0xc7, 0xeb, 0x97, 0xf8, 0x65, 0xf2, 0xf2, 0xac, 0x56, 0x7d, 0x2a, 0x3f, 0x7d, 0x0e, 0x04,
+	0x4b, 0x48, 0xa9, 0x40, 0x41, 0x2a, 0x17, 0x84, 0x38, 0xe0, 0x26, 0x9b, 0xd6, 0xc2, 0xd8, 0x61,
+	0xed, 0x54, 0xe2, 0x54, 0x39, 0x8e, 0x4b, 0xac, 0xba, 0x76, 0x65, 0xc7, 0x48, 0xf9, 0x20, 0x9c,
+	0x10, 0x07, 0x6e, 0x7c, 0x47, 0x2e, 0x68, 0xd7, 0x76, 0x68, 0x4b, 0x6e, 0xff, 0x99, 0xf9, 0xef,
+	0x38, 0x3b, 0xf3, 0xdb, 0x40, 0xff, 0x36, 0x8c, 0xd6, 0x49, 0x16, 0x8f, 0xef, 0x8a, 0x7c, 0x93,
+	0x63, 0xb5, 0x09, 0xcd, 0x1f, 0x22, 0xa8, 0x41, 0x7e, 0x13, 0x67, 0xb6, 0x83, 0x4f, 0x40, 0xbe,
+	0x49, 0xb2, 0x95, 0x21, 0x0c, 0x85, 0xd1, 0xe0, 0xf4, 0xdf, 0x71, 0x7b, 0xa4, 0xa9, 0x8f, 0xdf,
+	0x27, 0xd9, 0x8a, 0x72, 0x0b, 0x3e, 0x84, 0xce, 0x97, 0x30, 0xad, 0x62, 0x43, 0x1c, 0x0a, 0x23,
+	0x9d, 0xd6, 0x01, 0xc6, 0x20, 0xa7, 0x49, 0x16, 0x1b, 0xd2, 0x50, 0x18, 0xf5, 0x29, 0xd7, 0xf8,
+	0x08, 0x94, 0x28, 0x4f, 0xab, 0xdb, 0xcc, 0x90, 0x79, 0xb6, 0x89, 0xcc, 0x9f, 0x02, 0xc8, 0xac,
+	0x21, 0xd6, 0x40, 0x76, 0x3d, 0x97, 0xa0, 0x03, 0xac, 0x43, 0xe7, 0xd2, 0x72, 0x16, 0x04, 0x09,
+	0x2c, 0xe9, 0xcd, 0x89, 0x8b, 0x44, 0x96, 0x9c, 0x38, 0x9e, 0x4f, 0x90, 0x84, 0x55, 0x90, 0x88,
+	0x3b, 0x45, 0x32, 0x13, 0x53, 0x2f, 0x40, 0x1d, 0x66, 0x9b, 0xdb, 0x73, 0x82, 0x14, 0x0c, 0xa0,
+	0x58, 0xbe, 0x6f, 0x9f, 0xbb, 0x48, 0x65, 0xe5, 0x4b, 0x8b, 0x22, 0x8d, 0x25, 0xfd, 0x80, 0xda,
+	0xee, 0x39, 0xd2, 0x71, 0x0f, 0xb4, 0x8f, 0x0b, 0xe2, 0x07, 0xb6, 0xe7, 0x22, 0xe0, 0x5d, 0x3d,
+	0xc7, 0x73, 0x51, 0x97, 0x99, 0x9c, 0x33, 0x6a, 0x4d, 0x08, 0xea, 0x31, 0x4d, 0x6b, 0xdd, 0xe7,
+	0xbd, 0x9d, 0x85, 0x8f, 0x06, 0xe6, 0x37, 0x19, 0x14, 0x37, 0x5f, 0xc5, 0xb6, 0x83, 0x07, 0x20,
+	0x26, 0xf5, 0x80, 0x7a, 0x54, 0x4c, 0x56, 0x78, 0xd4, 0x8c, 0x4c, 0xe4, 0x23, 0x3b, 0xdc, 0x8d,
+	0xac, 0xb6, 0xdf, 0x9f, 0xd8, 0x73, 0xd0, 0xa2, 0x75, 0x92, 0xae, 0x8a, 0x38, 0x33, 0xa4, 0xa1,
+	0x34, 0xea, 0x9e, 0xfe, 0xf3, 0xc8, 0x4d, 0x77, 0x06, 0x7c, 0x02, 0x6a, 0xb4, 0x0e, 0x93, 0x2c,
+	0x5e, 0xf1, 0xa9, 0xed, 0xf1, 0xb6, 0x75, 0xfc, 0xa2, 0xdd, 0x44, 0x87, 0x1b, 0x8f, 0x1e, 0xff,
+	0x84, 0xe9, 0x25, 0xab, 0xb6, 0x1b, 0xfa, 0x0f, 0xb4, 0xb2, 0x5a, 0x5e, 0x6d, 0xb6, 0x77, 0xb1,
+	0xa1, 0xf0, 0xd5, 0xa9, 0x65, 0xb5, 0x0c, 0xb6, 0x77, 0xf1, 0xf1, 0x57, 0x01, 0x94, 0xda, 0x8c,
+	0x5f, 0x3e, 0x00, 0xe1, 0xff, 0xfd, 0x2d, 0xef, 0x5f, 0x0e, 0x81, 0x54, 0x6e, 0x8a, 0x06, 0x06,
+	0x26, 0x59, 0xe6, 0x3a, 0xdd, 0x70, 0x12, 0x04, 0xca, 0x24, 0x83, 0x63, 0x99, 0xe7, 0x29, 0xbf,
+	0x90, 0x46, 0xb9, 0x36, 0xcd, 0x86, 0x01, 0x15, 0x24, 0x3f, 0xa0, 0xe8, 0x80, 0x89, 0x99, 0x13,
+	0xd4, 0x00, 0x9c, 0x79, 0x9e, 0x83, 0x44, 0xf3, 0xfb, 0xdf, 0xa0, 0x68, 0x20, 0x53, 0xcf, 0x63,
+	0x36, 0x1d, 0x3a, 0xe7, 0xd4, 0x5b, 0xcc, 0x91, 0xc8, 0x92, 0xb3, 0x85, 0x3b, 0x41, 0xd2, 0x1f,
+	0x8e, 0xe4, 0x7b, 0x58, 0x74, 0x5a, 0x2c, 0x14, 0x26, 0x5c, 0x2b, 0x40, 0x2a, 0x5f, 0x37, 0x09,
+	0x16, 0xd4, 0x45, 0x1a, 0xee, 0x82, 0x1a, 0x10, 0xea, 0x5a, 0xf4, 0x13, 0xd2, 0x59, 0x87, 0x0f,
+	0x56, 0x30, 0xb9, 0x40, 0xc0, 0xda, 0x4e, 0x2c, 0x9f, 0xd4, 0xa0, 0x9c, 0xd9, 0xdc, 0xd0, 0x63,
+	0x86, 0x29, 0x99, 0x11, 0x8a, 0xfa, 0xe6, 0x2f, 0x01, 0xf4, 0x79, 0x91, 0x7f, 0x2e, 0xc2, 0xdb,
+	0x3d, 0x80, 0x1c, 0x81, 0x52, 0xe6, 0x55, 0x11, 0xb5, 0x2f, 0xa5, 0x89, 0xf0, 0x33, 0xe8, 0xc4,
+	0xd9, 0xa6, 0xd8, 0x1a, 0xd2, 0xfe, 0xfd, 0xd6, 0x55, 0xfc, 0x0e, 0xe0, 0xba, 0xca, 0xa2, 0xab,
+	0x28, 0x4c, 0xd3, 0xd2, 0x90, 0x39, 0x37, 0x4f, 0x77, 0xde, 0xdd, 0x67, 0xc7, 0xb3, 0x2a, 0x8b,
+	0x26, 0xcc, 0x43, 0xd8, 0x31, 0xaa, 0x5f, 0xb7, 0x31, 0x7e, 0x02, 0xdd, 0xfa, 0x93, 0x57, 0xeb,
+	0xb0, 0x5c, 0x73, 0x4a, 0x7a, 0x14, 0xea, 0xd4, 0x45, 0x58, 0xae, 0x8f, 0xdf, 0xc2, 0xe0, 0xe1,
+	0x69, 0xb6, 0xbb, 0x9b, 0x78, 0xcb, 0x2f, 0xa1, 0x53, 0x26, 0x1f, 0x3e, 0x77, 0xb9, 0x81, 0xe9,
+	0x8d, 0xf8, 0x5a, 0x58, 0x2a, 0xfc, 0xff, 0xe4, 0xd5, 0xef, 0x01, 0x00, 0x67, 0x89, 0x33, 0x8b,
+	0x60, 0x04, 0x00, 0x00,
 }
@@ -3,11 +3,14 @@ package machine_test
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/maddiesch/machine"
 	"github.com/stretchr/testify/assert"
@@ -48,15 +51,15 @@ func progFromBase64(str string) *ProgramIL {
 func impl() *Implementation {
 	i := &Implementation{}
 
-	i.Func("alert", func(metric string, operator string, value string) (string, error) {
-		return fmt.Sprintf("alert(%s, %s, %s)", metric, operator, value), nil
+	i.Func("alert", func(metric string, operator string, value float64) (string, error) {
+		return fmt.Sprintf("alert(%s, %s, %v)", metric, operator, value), nil
 	})
 
-	i.Func("warn", func(metric string, operator string, value string) (string, error) {
-		return fmt.Sprintf("warn(%s, %s, %s)", metric, operator, value), nil
+	i.Func("warn", func(metric string, operator string, value float64) (string, error) {
+		return fmt.Sprintf("warn(%s, %s, %v)", metric, operator, value), nil
 	})
 
-	i.Func("recover", func(ctx context.Context, operator string, value string) error {
+	i.Func("recover", func(ctx context.Context, operator string, value float64) error {
 		return nil
 	})
 
@@ -82,6 +85,22 @@ func impl() *Implementation {
 	return i
 }
 
+// Compiles the source and runs it in the machine, returning the program's value.
+func execValue(t *testing.T, m *Machine, src string) (interface{}, error) {
+	prog, err := CompileSource(src)
+
+	require.NoError(t, err)
+
+	return m.ExecuteValue(prog)
+}
+
+// Compiles the source and runs it in the machine.
+func exec(t *testing.T, m *Machine, src string) error {
+	_, err := execValue(t, m, src)
+
+	return err
+}
+
 func TestMachine(t *testing.T) {
 	t.Run("sanity check", func(t *testing.T) {
 		m := New(impl())
@@ -148,3 +167,2119 @@ func TestMachine(t *testing.T) {
 		})
 	})
 }
+
+func TestRuntimeErrorFrames(t *testing.T) {
+	i := &Implementation{}
+
+	i.Func("foo", func(in string) {})
+
+	err := Run(i, `foo(fatal(boom));`)
+
+	require.Error(t, err)
+
+	rErr, ok := err.(*RuntimeError)
+
+	require.True(t, ok)
+
+	assert.Equal(t, []FrameInfo{
+		{Kind: NodeIL_FUNC, Value: "fatal"},
+		{Kind: NodeIL_FUNC, Value: "foo"},
+		{Kind: NodeIL_ROOT},
+	}, rErr.Frames)
+}
+
+func TestRuntimeErrorLoc(t *testing.T) {
+	i := &Implementation{}
+
+	i.Func("foo", func(in interface{}) {})
+
+	err := Run(i, "const a = set(x);\nfoo(setf(1) + set(a));")
+
+	require.Error(t, err)
+
+	rErr, ok := err.(*RuntimeError)
+
+	require.True(t, ok)
+
+	// The address doesn't depend on the platform's pointer width
+	assert.Equal(t, uint64(0x1000000a), rErr.Loc)
+}
+
+func TestTruthiness(t *testing.T) {
+	check := func(t *testing.T, truthy bool, src string) (bool, error) {
+		var out bool
+
+		i := &Implementation{}
+		i.EnableTruthiness(truthy)
+		i.Func("check", func(in bool) {
+			out = in
+		})
+
+		err := Run(i, src)
+
+		return out, err
+	}
+
+	t.Run("given truthiness is disabled", func(t *testing.T) {
+		t.Run("bool conditions are allowed", func(t *testing.T) {
+			out, err := check(t, false, `check(not(false));`)
+
+			require.NoError(t, err)
+			assert.True(t, out)
+		})
+
+		t.Run("string conditions are a TypeError", func(t *testing.T) {
+			_, err := check(t, false, `check(not(foo));`)
+
+			require.Error(t, err)
+			assert.Equal(t, "Runtime Error: <TypeError> expected a bool condition, got string", err.Error())
+		})
+
+		t.Run("numeric conditions are a TypeError", func(t *testing.T) {
+			_, err := check(t, false, `check(and(true f1.0));`)
+
+			require.Error(t, err)
+			assert.Equal(t, "Runtime Error: <TypeError> expected a bool condition, got float64", err.Error())
+		})
+	})
+
+	t.Run("given truthiness is enabled", func(t *testing.T) {
+		t.Run("a non-empty string is true", func(t *testing.T) {
+			out, err := check(t, true, `check(and(foo true));`)
+
+			require.NoError(t, err)
+			assert.True(t, out)
+		})
+
+		t.Run("an empty string is false", func(t *testing.T) {
+			out, err := check(t, true, `check(or(env(missing) false));`)
+
+			require.NoError(t, err)
+			assert.False(t, out)
+		})
+
+		t.Run("a non-zero number is true", func(t *testing.T) {
+			out, err := check(t, true, `check(not(f0.5));`)
+
+			require.NoError(t, err)
+			assert.False(t, out)
+		})
+
+		t.Run("a zero number is false", func(t *testing.T) {
+			out, err := check(t, true, `check(not(f0.0));`)
+
+			require.NoError(t, err)
+			assert.True(t, out)
+		})
+	})
+}
+
+func TestInlineAssignment(t *testing.T) {
+	var calls []string
+
+	i := &Implementation{}
+	i.Func("echo", func(in string) string {
+		calls = append(calls, in)
+		return in
+	})
+
+	prog, err := CompileSource("echo(const b = env(region));\necho($b);")
+
+	require.NoError(t, err)
+
+	assert.Equal(t, "echo(const b = env(region));\necho($b);\n", prog.Source)
+
+	m := New(i)
+	defer m.Shutdown()
+	m.Setenv("region", "us-east-1")
+
+	err = m.Execute(prog)
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"us-east-1", "us-east-1"}, calls)
+
+	t.Run("the assignment can be followed by more arguments", func(t *testing.T) {
+		var out []string
+
+		i := &Implementation{}
+		i.Func("pair", func(a string, b string) {
+			out = []string{a, b}
+		})
+
+		err := Run(i, `pair(const a = set(one) two);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"one", "two"}, out)
+	})
+}
+
+func TestReturn(t *testing.T) {
+	run := func(t *testing.T, src string) (interface{}, bool, error) {
+		var called bool
+
+		i := &Implementation{}
+		i.Func("foo", func() {
+			called = true
+		})
+
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		m := New(i)
+		defer m.Shutdown()
+
+		v, err := m.ExecuteValue(prog)
+
+		return v, called, err
+	}
+
+	t.Run("given a return with a value", func(t *testing.T) {
+		v, called, err := run(t, "return set(done);\nfoo();")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "done", v)
+		assert.False(t, called)
+	})
+
+	t.Run("given a return without a value", func(t *testing.T) {
+		v, called, err := run(t, "return;\nfoo();")
+
+		require.NoError(t, err)
+
+		assert.Nil(t, v)
+		assert.False(t, called)
+	})
+
+	t.Run("given no return", func(t *testing.T) {
+		v, called, err := run(t, "set(done);\nfoo();")
+
+		require.NoError(t, err)
+
+		assert.Nil(t, v)
+		assert.True(t, called)
+	})
+
+	t.Run("given a return of something that isn't a call", func(t *testing.T) {
+		_, err := CompileSource(`return foo bar;`)
+
+		require.Error(t, err)
+
+		_, ok := err.(*SyntaxError)
+
+		assert.True(t, ok)
+	})
+
+	t.Run("the generated source compiles to the same program", func(t *testing.T) {
+		prog, err := CompileSource("return (set(a)|set(b));\nreturn;")
+
+		require.NoError(t, err)
+
+		p2, err := CompileSource(prog.Source)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+	})
+}
+
+func TestMachineDecide(t *testing.T) {
+	i := &Implementation{}
+	i.Func("busy", func(load float64) bool { return load > 0.5 })
+	i.Func("count", func() float64 { return 3 })
+
+	decide := func(t *testing.T, i *Implementation, src string) (bool, error) {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		return NewSync(i).Decide(prog)
+	}
+
+	t.Run("given a program that returns true", func(t *testing.T) {
+		ok, err := decide(t, i, "return busy(f0.8);")
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("given a program that returns false", func(t *testing.T) {
+		ok, err := decide(t, i, "return busy(f0.2);")
+
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("given a program without a value", func(t *testing.T) {
+		_, err := decide(t, i, "busy(f0.8);")
+
+		require.Error(t, err)
+		assert.Equal(t, "NoValue", err.(*RuntimeError).Code)
+	})
+
+	t.Run("given a value that isn't a bool", func(t *testing.T) {
+		_, err := decide(t, i, "return count();")
+
+		require.Error(t, err)
+		assert.Equal(t, "TypeError", err.(*RuntimeError).Code)
+	})
+
+	t.Run("given a value that isn't a bool with truthiness", func(t *testing.T) {
+		ti := &Implementation{}
+		ti.Func("count", func() float64 { return 3 })
+		ti.EnableTruthiness(true)
+
+		ok, err := decide(t, ti, "return count();")
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestInterpolation(t *testing.T) {
+	run := func(t *testing.T, enabled bool, src string) string {
+		var out string
+
+		i := &Implementation{}
+		i.EnableInterpolation(enabled)
+		i.Func("alert", func(in string) {
+			out = in
+		})
+
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		m := New(i)
+		defer m.Shutdown()
+		m.Setenv("REGION", "us-east-1")
+
+		require.NoError(t, m.Execute(prog))
+
+		return out
+	}
+
+	t.Run("given a present variable", func(t *testing.T) {
+		assert.Equal(t, "cpu on us-east-1", run(t, true, `alert("cpu on ${REGION}");`))
+	})
+
+	t.Run("given a missing variable", func(t *testing.T) {
+		assert.Equal(t, "cpu on ", run(t, true, `alert("cpu on ${ZONE}");`))
+	})
+
+	t.Run("given an escaped interpolation", func(t *testing.T) {
+		assert.Equal(t, "cpu on ${REGION}", run(t, true, `alert("cpu on $${REGION}");`))
+	})
+
+	t.Run("given interpolation is disabled", func(t *testing.T) {
+		assert.Equal(t, "cpu on ${REGION}", run(t, false, `alert("cpu on ${REGION}");`))
+	})
+}
+
+func TestExecuteWithFuncs(t *testing.T) {
+	var got string
+
+	m := New(&Implementation{})
+	defer m.Shutdown()
+
+	prog, err := CompileSource(`callback(done);`)
+
+	require.NoError(t, err)
+
+	err = m.ExecuteWithFuncs(prog, map[string]interface{}{
+		"callback": func(in string) {
+			got = in
+		},
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, "done", got)
+
+	t.Run("the function isn't available on the next run", func(t *testing.T) {
+		err := m.Execute(prog)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <Unsupported> program requires unavailable functions 'callback'", err.Error())
+	})
+
+	t.Run("given a function the machine already has", func(t *testing.T) {
+		err := m.ExecuteWithFuncs(prog, map[string]interface{}{
+			"env": func(in string) {},
+		})
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <InvalidFunc> attempting to redefine a function with name 'env'", err.Error())
+	})
+
+	t.Run("given a handler that isn't a function", func(t *testing.T) {
+		err := m.ExecuteWithFuncs(prog, map[string]interface{}{
+			"callback": "not a function",
+		})
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <InvalidFunc> attempting to add a function 'callback' without a function handler", err.Error())
+	})
+
+	t.Run("given a case insensitive implementation", func(t *testing.T) {
+		var called bool
+
+		i := &Implementation{}
+		i.SetCaseInsensitive(true)
+
+		prog, err := CompileSource(`bar();`)
+
+		require.NoError(t, err)
+
+		err = NewSync(i).ExecuteWithFuncs(prog, map[string]interface{}{
+			"Bar": func() { called = true },
+		})
+
+		require.NoError(t, err)
+		assert.True(t, called)
+	})
+}
+
+func TestMachineSetTransform(t *testing.T) {
+	var calls []string
+
+	i := &Implementation{}
+	i.Func("alert", func(in string) {
+		calls = append(calls, "alert("+in+")")
+	})
+	i.Func("warn", func(in string) {
+		calls = append(calls, "warn("+in+")")
+	})
+
+	m := New(i)
+	defer m.Shutdown()
+
+	m.SetTransform(func(p *ProgramIL) (*ProgramIL, error) {
+		entry := Clone(p.Entry)
+
+		Walk(entry, func(n *NodeIL) bool {
+			if n.Kind == NodeIL_FUNC && n.Value.Str == "alert" {
+				n.Value.Str = "warn"
+			}
+			return true
+		})
+
+		return &ProgramIL{Id: p.Id, Source: p.Source, Entry: entry, FuncCalls: map[string]uint64{"warn": p.FuncCalls["alert"]}}, nil
+	})
+
+	prog, err := CompileSource("alert(cpu);\nalert(mem);")
+
+	require.NoError(t, err)
+
+	require.NoError(t, m.Execute(prog))
+
+	assert.Equal(t, []string{"warn(cpu)", "warn(mem)"}, calls)
+
+	t.Run("the original program isn't changed", func(t *testing.T) {
+		assert.Equal(t, "alert", prog.Entry.Children[0].Value.Str)
+	})
+
+	t.Run("given a transform that fails", func(t *testing.T) {
+		m.SetTransform(func(p *ProgramIL) (*ProgramIL, error) {
+			return nil, fmt.Errorf("rejected")
+		})
+
+		err := m.Execute(prog)
+
+		assert.EqualError(t, err, "rejected")
+	})
+
+	t.Run("given a transform that returns a nil program", func(t *testing.T) {
+		m.SetTransform(func(p *ProgramIL) (*ProgramIL, error) {
+			return nil, nil
+		})
+
+		err := m.Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "InvalidTransform", err.(*RuntimeError).Code)
+	})
+}
+
+func TestNewSync(t *testing.T) {
+	var got string
+
+	i := &Implementation{}
+	i.Func("foo", func(in string) {
+		got = in
+	})
+
+	prog, err := CompileSource(`foo(bar);`)
+
+	require.NoError(t, err)
+
+	before := runtime.NumGoroutine()
+
+	m := NewSync(i)
+
+	require.NoError(t, m.Execute(prog))
+
+	assert.Equal(t, before, runtime.NumGoroutine(), "no goroutines are started")
+	assert.Equal(t, "bar", got)
+
+	t.Run("errors are returned", func(t *testing.T) {
+		p, err := CompileSource(`fatal(boom);`)
+
+		require.NoError(t, err)
+
+		assert.EqualError(t, m.Execute(p), "Runtime Error: <Fatal> boom")
+	})
+}
+
+func TestMachineSetErrorHandler(t *testing.T) {
+	m := New(&Implementation{})
+	defer m.Shutdown()
+
+	var seen []string
+
+	m.SetErrorHandler(func(p *ProgramIL, err error) error {
+		seen = append(seen, err.Error())
+
+		if rErr, ok := err.(*RuntimeError); ok && rErr.Code == "Fatal" {
+			return nil
+		}
+		return err
+	})
+
+	t.Run("given an error the handler suppresses", func(t *testing.T) {
+		prog, err := CompileSource(`fatal(boom);`)
+
+		require.NoError(t, err)
+
+		assert.NoError(t, m.Execute(prog))
+	})
+
+	t.Run("given an error the handler returns", func(t *testing.T) {
+		prog, err := CompileSource(`set($missing);`)
+
+		require.NoError(t, err)
+
+		assert.Error(t, m.Execute(prog))
+	})
+
+	t.Run("given a successful program", func(t *testing.T) {
+		prog, err := CompileSource(`env(foo);`)
+
+		require.NoError(t, err)
+
+		assert.NoError(t, m.Execute(prog))
+	})
+
+	assert.Len(t, seen, 2)
+}
+
+func TestDefer(t *testing.T) {
+	var calls []string
+
+	i := &Implementation{}
+	i.Func("call", func(name string) {
+		calls = append(calls, name)
+	})
+	i.Func("fail", func(msg string) error {
+		calls = append(calls, "fail "+msg)
+		return fmt.Errorf("%s", msg)
+	})
+
+	t.Run("given a program that succeeds", func(t *testing.T) {
+		calls = nil
+
+		v, err := execValue(t, NewSync(i), "defer call(release-a);\ndefer (call(release-b)|call(release-c));\ncall(work);\nreturn set(done);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "done", v)
+		assert.Equal(t, []string{"work", "release-b", "release-c", "release-a"}, calls)
+	})
+
+	t.Run("given a program that fails", func(t *testing.T) {
+		calls = nil
+
+		_, err := execValue(t, NewSync(i), "defer call(release);\nfail(work);\ncall(never);")
+
+		require.Error(t, err)
+
+		assert.Equal(t, "work", err.Error())
+		assert.Equal(t, []string{"fail work", "release"}, calls)
+	})
+
+	t.Run("given a deferred call that fails", func(t *testing.T) {
+		calls = nil
+
+		_, err := execValue(t, NewSync(i), "defer fail(first);\ndefer fail(last);\ncall(work);")
+
+		require.Error(t, err)
+
+		assert.Equal(t, "last", err.Error())
+		assert.Equal(t, []string{"work", "fail last", "fail first"}, calls)
+	})
+
+	t.Run("given a program and a deferred call that fail", func(t *testing.T) {
+		calls = nil
+
+		_, err := execValue(t, NewSync(i), "defer fail(cleanup);\nfail(work);")
+
+		require.Error(t, err)
+
+		assert.Equal(t, "work", err.Error())
+		assert.Equal(t, []string{"fail work", "fail cleanup"}, calls)
+	})
+
+	t.Run("given a defer without a call", func(t *testing.T) {
+		_, err := CompileSource("defer;")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Unexpected defer.")
+	})
+
+	t.Run("the generated source compiles to the same program", func(t *testing.T) {
+		prog, err := CompileSource("defer (call(a)|call(b));\ndefer call(c);")
+
+		require.NoError(t, err)
+
+		p2, err := CompileSource(prog.Source)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+	})
+}
+
+func TestAddOperator(t *testing.T) {
+	i := &Implementation{}
+	i.Func("count", func() int { return 2 })
+
+	t.Run("given two strings", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "const value = \"80\";\nreturn set(\"cpu: \" + $value);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu: 80", v)
+	})
+
+	t.Run("given two numbers", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return setf(1 + f2.5);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 3.5, v)
+	})
+
+	t.Run("given a number from a function", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return setf(count() + 1 + 2);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(5), v)
+	})
+
+	t.Run("given a string and a number", func(t *testing.T) {
+		_, err := execValue(t, NewSync(i), `return set("cpu: " + 80);`)
+
+		require.Error(t, err)
+		assert.Equal(t, "TypeError", err.(*RuntimeError).Code)
+		assert.Contains(t, err.Error(), "Attempting to add string \"cpu: \" and float64 80.")
+	})
+
+	t.Run("given a ternary", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return set(false ? a : b + c);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "bc", v)
+	})
+
+	t.Run("given a statement", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return set(a) + set(b);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "ab", v)
+	})
+
+	t.Run("given a value containing a +", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return set(2019-10-12T07:20:50+00:00);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "2019-10-12T07:20:50+00:00", v)
+	})
+
+	t.Run("given nothing before the operator", func(t *testing.T) {
+		_, err := CompileSource(`set(+ a);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Expected a value before it.")
+	})
+
+	t.Run("the generated source compiles to the same program", func(t *testing.T) {
+		prog, err := CompileSource(`set("a" + $b + c(d));`)
+
+		require.NoError(t, err)
+
+		p2, err := CompileSource(prog.Source)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+	})
+}
+
+func TestErrorRenderedValues(t *testing.T) {
+	i := &Implementation{}
+	i.Func("value", func(kind string) interface{} {
+		switch kind {
+		case "string":
+			return "cpu \"p99\""
+		case "float":
+			return 0.25
+		case "int":
+			return -3
+		case "bool":
+			return false
+		case "slice":
+			return []interface{}{"a", 1.5, true}
+		case "map":
+			return map[string]int{"b": 2, "a": 1}
+		default:
+			return nil
+		}
+	})
+	i.Func("nothing", func() {})
+
+	m := NewSync(i)
+
+	rendered := map[string]string{
+		"string": `string "cpu \"p99\""`,
+		"float":  `float64 0.25`,
+		"int":    `int -3`,
+		"bool":   `bool false`,
+		"slice":  `[]interface {} ["a", 1.5, true]`,
+		"map":    `map[string]int {"a": 1, "b": 2}`,
+		"nil":    `nil`,
+	}
+
+	for kind, expected := range rendered {
+		t.Run("given a "+kind, func(t *testing.T) {
+			err := exec(t, m, fmt.Sprintf("set(value(%s) + true);", kind))
+
+			require.Error(t, err)
+			assert.Equal(t, fmt.Sprintf("Runtime Error: <TypeError> Attempting to add %s and bool true. Both values must be strings or numbers.", expected), err.Error())
+		})
+	}
+
+	t.Run("given a function without a return value", func(t *testing.T) {
+		err := exec(t, m, "set(nothing());")
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <MissingReturnValue> no return value found for FUNC nothing", err.Error())
+	})
+}
+
+func TestTernary(t *testing.T) {
+	run := func(t *testing.T, truthy bool, src string) (interface{}, []string, error) {
+		var calls []string
+
+		i := &Implementation{}
+		i.EnableTruthiness(truthy)
+		i.Func("busy", func(v bool) bool {
+			return v
+		})
+		i.Func("pick", func(name string) string {
+			calls = append(calls, name)
+			return name
+		})
+
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		v, err := NewSync(i).ExecuteValue(prog)
+
+		return v, calls, err
+	}
+
+	t.Run("given a true condition", func(t *testing.T) {
+		v, calls, err := run(t, false, `return set(busy(true) ? pick(cpu) : pick(mem));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu", v)
+		assert.Equal(t, []string{"cpu"}, calls)
+	})
+
+	t.Run("given a false condition", func(t *testing.T) {
+		v, calls, err := run(t, false, `return set(busy(false) ? pick(cpu) : pick(mem));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "mem", v)
+		assert.Equal(t, []string{"mem"}, calls)
+	})
+
+	t.Run("given an assigned ternary", func(t *testing.T) {
+		v, _, err := run(t, false, "const a = busy(true) ? \"cpu\" : \"mem\";\nreturn set($a);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu", v)
+	})
+
+	t.Run("given nested ternaries", func(t *testing.T) {
+		v, _, err := run(t, false, `return set(false ? one : true ? two : three);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "two", v)
+	})
+
+	t.Run("given a condition that isn't a bool", func(t *testing.T) {
+		_, _, err := run(t, false, `return set(foo ? one : two);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected a bool condition, got string")
+	})
+
+	t.Run("given truthiness is enabled", func(t *testing.T) {
+		v, _, err := run(t, true, `return set(env(missing) ? one : two);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "two", v)
+	})
+
+	t.Run("the generated source compiles to the same program", func(t *testing.T) {
+		prog, err := CompileSource(`set(busy(true) ? pick(cpu) : 2019-10-12T07:20:50Z);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "set(busy(true) ? pick(cpu) : 2019-10-12T07:20:50Z);\n", prog.Source)
+
+		ok, err := SourceEqual(prog.Source, `set(busy(true) ? pick(cpu) : 2019-10-12T07:20:50Z);`)
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("given a ternary without a :", func(t *testing.T) {
+		_, err := CompileSource(`set(true ? one two);`)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestMacStackDepth(t *testing.T) {
+	var depths []int
+
+	i := &Implementation{}
+	i.Func("depth", func(ctx context.Context) string {
+		depths = append(depths, Mac(ctx).StackDepth())
+		return ""
+	})
+	i.Func("outer", func(string) {})
+
+	err := Run(i, "depth();\nouter(depth());")
+
+	require.NoError(t, err)
+	require.Len(t, depths, 2)
+
+	assert.True(t, depths[0] > 1)
+	assert.Equal(t, depths[0]+1, depths[1])
+}
+
+func TestMacExecutionCount(t *testing.T) {
+	var counts []uint64
+
+	i := &Implementation{}
+	i.Func("count", func(ctx context.Context) {
+		counts = append(counts, Mac(ctx).ExecutionCount())
+	})
+	i.Func("fail", func() error {
+		return fmt.Errorf("failed")
+	})
+
+	m := NewSync(i)
+
+	prog, err := CompileSource("count();\ncount();")
+
+	require.NoError(t, err)
+
+	failing, err := CompileSource("fail();")
+
+	require.NoError(t, err)
+
+	require.NoError(t, m.Execute(prog))
+	require.NoError(t, m.Execute(prog))
+	require.Error(t, m.Execute(failing))
+	require.NoError(t, m.Execute(prog))
+
+	assert.Equal(t, []uint64{0, 0, 1, 1, 3, 3}, counts)
+}
+
+func TestMacProgramID(t *testing.T) {
+	var ids [][]byte
+
+	i := &Implementation{}
+	i.Func("log", func(ctx context.Context) {
+		ids = append(ids, Mac(ctx).ProgramID())
+	})
+
+	m := NewSync(i)
+
+	first, err := CompileSource("log();")
+
+	require.NoError(t, err)
+
+	second, err := CompileSource("log();")
+
+	require.NoError(t, err)
+
+	require.NoError(t, m.Execute(first))
+	require.NoError(t, m.Execute(second))
+
+	require.Len(t, ids, 2)
+	assert.Equal(t, first.Id, ids[0])
+	assert.Equal(t, second.Id, ids[1])
+	assert.NotEqual(t, ids[0], ids[1])
+}
+
+func TestAssignValue(t *testing.T) {
+	t.Run("given a string", func(t *testing.T) {
+		v, err := eval(t, "const a = \"x\";\nreturn set($a);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "x", v)
+	})
+
+	t.Run("given a float", func(t *testing.T) {
+		v, err := eval(t, "const b = f1.5;\nreturn setf($b);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 1.5, v)
+	})
+
+	t.Run("given a variable", func(t *testing.T) {
+		v, err := eval(t, "const other = set(y);\nconst c = $other;\nreturn set($c);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "y", v)
+	})
+
+	t.Run("given an inline assignment", func(t *testing.T) {
+		v, err := eval(t, "return format(\"{} {}\" const a = \"x\" $a);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "x x", v)
+	})
+
+	t.Run("given more than one value", func(t *testing.T) {
+		_, err := CompileSource(`const a = "x" "y";`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Expected a `;`")
+	})
+}
+
+func TestAssignMultiple(t *testing.T) {
+	i := &Implementation{}
+	i.Func("split-host-port", func(s string) []string {
+		return strings.SplitN(s, ":", 2)
+	})
+
+	t.Run("given a slice", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "const host, port = split-host-port(\"example.com:80\");\nreturn format(\"{} {}\" $host $port);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "example.com 80", v)
+	})
+
+	t.Run("given a group", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "const a, b = (set(x)|set(y));\nreturn format(\"{} {}\" $a $b);")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "x y", v)
+	})
+
+	t.Run("given too few values", func(t *testing.T) {
+		_, err := execValue(t, NewSync(i), "const a, b, c = split-host-port(\"example.com:80\");")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Attempting to assign 3 names from 2 values.")
+	})
+
+	t.Run("given a value that isn't a slice", func(t *testing.T) {
+		_, err := execValue(t, NewSync(i), "const a, b = set(x);")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Attempting to assign more than one name from string \"x\", expected a slice.")
+	})
+
+	t.Run("given an inline assignment", func(t *testing.T) {
+		_, err := CompileSource("set(const a, b = set(x));")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Assigning more than one name can't be used as an argument.")
+	})
+}
+
+func TestMachinePreflight(t *testing.T) {
+	i := &Implementation{}
+	i.DisableNative("_delete")
+
+	m := NewSync(i)
+
+	t.Run("given a disabled native function", func(t *testing.T) {
+		prog, err := CompileSource("const a = set(b);\n_delete(a);")
+
+		require.NoError(t, err)
+
+		err = m.Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <Unsupported> program requires unavailable native functions '_delete'", err.Error())
+	})
+
+	t.Run("given missing functions and a disabled native function", func(t *testing.T) {
+		prog, err := CompileSource("foo(bar(a));\n_delete(a);\nset(b);")
+
+		require.NoError(t, err)
+
+		err = m.Preflight(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <Unsupported> program requires unavailable functions 'bar', 'foo' and native functions '_delete'", err.Error())
+	})
+
+	t.Run("given a supported program", func(t *testing.T) {
+		prog, err := CompileSource(`set(format("{}" b));`)
+
+		require.NoError(t, err)
+
+		assert.NoError(t, m.Preflight(prog))
+	})
+
+	t.Run("given an unknown native function", func(t *testing.T) {
+		assert.Panics(t, func() {
+			(&Implementation{}).DisableNative("_missing")
+		})
+	})
+}
+
+func TestValidate(t *testing.T) {
+	dev := &Implementation{}
+	dev.Func("page", func() {})
+	dev.Func("scale-up", func(app string) {})
+
+	prod := &Implementation{}
+	prod.Func("page", func() {})
+	prod.DisableNative("_delete")
+
+	prog, err := CompileSource("const a = set(x);\npage();\nscale-up(env(app));\n_delete(a);")
+
+	require.NoError(t, err)
+
+	results := Validate(prog, dev, prod)
+
+	require.Len(t, results, 2)
+	assert.NoError(t, results["0"])
+
+	require.Error(t, results["1"])
+	assert.Equal(t, "Runtime Error: <Unsupported> program requires unavailable functions 'scale-up' and native functions '_delete'", results["1"].Error())
+}
+
+func TestCompileAndValidate(t *testing.T) {
+	i := &Implementation{}
+	i.Func("page", func(team string) {})
+	i.Func("notify", func(channel string, ids ...string) {})
+	i.FuncWithSignature("alert", []string{"metric", "operator", "value"}, func(metric string, operator string, value float64) {})
+
+	t.Run("given a valid program", func(t *testing.T) {
+		prog, err := CompileAndValidate("page(ops);\nnotify(#team a b);\nalert(input(args));", i)
+
+		require.NoError(t, err)
+		assert.NotNil(t, prog)
+	})
+
+	t.Run("given an arity mismatch", func(t *testing.T) {
+		_, err := CompileAndValidate("page();\nnotify();", i)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <InvalidProgram> Attempting to call 'page' with 0 arguments. Expected 1. Attempting to call 'notify' with 0 arguments. Expected at least 1", err.Error())
+	})
+
+	t.Run("given missing functions and an arity mismatch", func(t *testing.T) {
+		_, err := CompileAndValidate("alert(cpu GT);\nscale-up(app);", i)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <InvalidProgram> program requires unavailable functions 'scale-up'. Attempting to call 'alert' with 2 arguments. Expected 3, missing 'value'", err.Error())
+	})
+
+	t.Run("given a syntax error", func(t *testing.T) {
+		_, err := CompileAndValidate("page(", i)
+
+		require.Error(t, err)
+
+		_, ok := err.(*SyntaxError)
+		assert.True(t, ok)
+	})
+}
+
+func TestChainingFromNil(t *testing.T) {
+	type result struct {
+		Value string
+	}
+
+	var called bool
+
+	i := &Implementation{}
+	i.Func("find", func(found bool) *result {
+		if found {
+			return &result{Value: "found"}
+		}
+		return nil
+	})
+	i.Func("use", func() string {
+		called = true
+		return "used"
+	})
+
+	t.Run("given a nil result", func(t *testing.T) {
+		called = false
+
+		_, err := execValue(t, NewSync(i), `return find(false).use();`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ChainingFromNil> Attempting to chain from 'find' but it returned nil")
+		assert.False(t, called)
+	})
+
+	t.Run("given a result", func(t *testing.T) {
+		called = false
+
+		v, err := execValue(t, NewSync(i), `return find(true).use();`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "used", v)
+		assert.True(t, called)
+	})
+
+	t.Run("given a nil result without a chain", func(t *testing.T) {
+		called = false
+
+		v, err := execValue(t, NewSync(i), `return find(false);`)
+
+		require.NoError(t, err)
+		assert.Nil(t, v)
+	})
+}
+
+func TestMachineSetVarHook(t *testing.T) {
+	var events []string
+
+	m := NewSync(&Implementation{})
+	m.SetVarHook(func(name string, v interface{}) {
+		events = append(events, fmt.Sprintf("assign %s=%v", name, v))
+	}, func(name string, v interface{}) {
+		events = append(events, fmt.Sprintf("read %s=%v", name, v))
+	})
+
+	prog, err := CompileSource("const a = set(x);\nconst b = format(\"{}{}\" $a $a);\nreturn set($b);")
+
+	require.NoError(t, err)
+
+	v, err := m.ExecuteValue(prog)
+
+	require.NoError(t, err)
+
+	assert.Equal(t, "xx", v)
+	assert.Equal(t, []string{"assign a=x", "read a=x", "read a=x", "assign b=xx", "read b=xx"}, events)
+
+	t.Run("given only an assign hook", func(t *testing.T) {
+		events = nil
+
+		m.SetVarHook(func(name string, v interface{}) {
+			events = append(events, name)
+		}, nil)
+
+		_, err := m.ExecuteValue(prog)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a", "b"}, events)
+	})
+}
+
+func TestMachineSetCircuitBreaker(t *testing.T) {
+	fail := true
+
+	i := &Implementation{}
+	i.Func("check", func() error {
+		if fail {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+
+	m := NewSync(i)
+	m.SetCircuitBreaker(2, 50*time.Millisecond)
+
+	prog, err := CompileSource(`check();`)
+
+	require.NoError(t, err)
+
+	other, err := CompileSource(`set(ok);`)
+
+	require.NoError(t, err)
+
+	code := func(err error) string {
+		if rErr, ok := err.(*RuntimeError); ok {
+			return rErr.Code
+		}
+		return ""
+	}
+
+	t.Run("the circuit opens after the threshold", func(t *testing.T) {
+		assert.EqualError(t, m.Execute(prog), "failed")
+		assert.EqualError(t, m.Execute(prog), "failed")
+		assert.Equal(t, "CircuitOpen", code(m.Execute(prog)))
+	})
+
+	t.Run("other programs still run", func(t *testing.T) {
+		assert.NoError(t, m.Execute(other))
+	})
+
+	t.Run("a failure after the cooldown opens the circuit again", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+
+		assert.EqualError(t, m.Execute(prog), "failed")
+		assert.Equal(t, "CircuitOpen", code(m.Execute(prog)))
+	})
+
+	t.Run("a success after the cooldown closes the circuit", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+
+		fail = false
+
+		assert.NoError(t, m.Execute(prog))
+
+		fail = true
+
+		assert.EqualError(t, m.Execute(prog), "failed")
+		assert.EqualError(t, m.Execute(prog), "failed")
+	})
+}
+
+func TestMatch(t *testing.T) {
+	const src = "match (env(metric)) {\n\"cpu\": pick(cpu-alert);\n42: pick(answer);\ndefault: pick(other);\n};\nreturn set(done);"
+
+	run := func(t *testing.T, src string, metric string) ([]string, error) {
+		var picked []string
+
+		i := &Implementation{}
+		i.Func("pick", func(name string) {
+			picked = append(picked, name)
+		})
+
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		m := NewSync(i)
+		m.Setenv("metric", metric)
+
+		v, err := m.ExecuteValue(prog)
+		if err == nil {
+			assert.Equal(t, "done", v)
+		}
+
+		return picked, err
+	}
+
+	t.Run("given a matching case", func(t *testing.T) {
+		picked, err := run(t, src, "cpu")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"cpu-alert"}, picked)
+	})
+
+	t.Run("given no matching case", func(t *testing.T) {
+		picked, err := run(t, src, "mem")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"other"}, picked)
+	})
+
+	t.Run("given no matching case or default", func(t *testing.T) {
+		picked, err := run(t, "match (env(metric)) {\n\"cpu\": pick(cpu-alert);\n}\nreturn set(done);", "mem")
+
+		require.NoError(t, err)
+
+		assert.Empty(t, picked)
+	})
+
+	t.Run("given a number", func(t *testing.T) {
+		picked, err := run(t, "match (42) {\n\"42\": pick(string);\n42: pick(number);\n};\nreturn set(done);", "")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"number"}, picked)
+	})
+
+	t.Run("given a return in a case", func(t *testing.T) {
+		i := &Implementation{}
+		i.Func("pick", func(name string) {
+			t.Errorf("unexpected call to pick(%s)", name)
+		})
+
+		v, err := execValue(t, NewSync(i), "match (set(a)) {\n\"a\": return set(x);\n}\npick(after);")
+
+		require.NoError(t, err)
+		assert.Equal(t, "x", v)
+	})
+
+	t.Run("given a defer in a case", func(t *testing.T) {
+		picked, err := run(t, "match (env(metric)) {\n\"cpu\": defer pick(later);\n}\npick(now);\nreturn set(done);", "cpu")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"now", "later"}, picked)
+	})
+
+	t.Run("the generated source compiles to the same program", func(t *testing.T) {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		ok, err := SourceEqual(prog.Source, src)
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("a function named match can still be called", func(t *testing.T) {
+		prog, err := CompileSource(`match(foo);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]uint64{"match": 1}, prog.FuncCalls)
+	})
+
+	t.Run("given a match without a closing brace", func(t *testing.T) {
+		_, err := CompileSource("match (env(metric)) {\n\"cpu\": pick(cpu-alert);")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("given a case that isn't a value", func(t *testing.T) {
+		_, err := CompileSource("match (env(metric)) {\nset(a): pick(cpu-alert);\n}")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestRetryableError(t *testing.T) {
+	unavailable := fmt.Errorf("service unavailable")
+
+	i := &Implementation{}
+	i.Func("flaky", func() error {
+		return &RetryableError{Err: unavailable}
+	})
+	i.Func("broken", func() error {
+		return fmt.Errorf("broken")
+	})
+
+	t.Run("given a retryable error", func(t *testing.T) {
+		err := exec(t, NewSync(i), "flaky();")
+
+		require.Error(t, err)
+
+		var re *RetryableError
+
+		require.True(t, errors.As(err, &re))
+		assert.True(t, errors.Is(err, unavailable))
+		assert.Equal(t, "Retryable Error: service unavailable", err.Error())
+	})
+
+	t.Run("given another error", func(t *testing.T) {
+		err := exec(t, NewSync(i), "broken();")
+
+		require.Error(t, err)
+
+		var re *RetryableError
+
+		assert.False(t, errors.As(err, &re))
+	})
+}
+
+func TestMachineExecuteAsync(t *testing.T) {
+	i := &Implementation{}
+	i.Func("fail", func() error {
+		return fmt.Errorf("failed")
+	})
+
+	m := New(i)
+	defer m.Shutdown()
+
+	wait := func(t *testing.T, src string) error {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		m.ExecuteAsync(prog, func(err error) {
+			done <- err
+		})
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("the callback wasn't called")
+			return nil
+		}
+	}
+
+	t.Run("given a program that succeeds", func(t *testing.T) {
+		assert.NoError(t, wait(t, `set(a);`))
+	})
+
+	t.Run("given a program that fails", func(t *testing.T) {
+		err := wait(t, `fail();`)
+
+		require.Error(t, err)
+		assert.Equal(t, "failed", err.Error())
+	})
+
+	t.Run("given a machine that has been shutdown", func(t *testing.T) {
+		m := New(i)
+		m.Shutdown()
+
+		prog, err := CompileSource(`set(a);`)
+
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		m.ExecuteAsync(prog, func(err error) {
+			done <- err
+		})
+
+		select {
+		case err := <-done:
+			require.Error(t, err)
+			assert.Equal(t, "Shutdown", err.(*RuntimeError).Code)
+		case <-time.After(time.Second):
+			t.Fatal("the callback wasn't called")
+		}
+	})
+}
+
+func TestMachineShutdownCancelsContext(t *testing.T) {
+	started := make(chan struct{})
+
+	i := &Implementation{}
+	i.Func("block", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	prog, err := CompileSource(`block();`)
+
+	require.NoError(t, err)
+
+	m := New(i)
+
+	done := make(chan error)
+	go func() {
+		done <- m.Execute(prog)
+	}()
+
+	<-started
+
+	m.Shutdown()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("the handler's context wasn't canceled")
+	}
+
+	t.Run("a synchronous machine can't run after shutdown", func(t *testing.T) {
+		m := NewSync(&Implementation{})
+		m.Shutdown()
+
+		prog, err := CompileSource(`set(a);`)
+
+		require.NoError(t, err)
+
+		err = m.Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <Shutdown> the machine has been shutdown", err.Error())
+	})
+}
+
+func TestGroupResults(t *testing.T) {
+	var results []interface{}
+	var grouped bool
+
+	i := &Implementation{}
+	i.Func("a", func() string { return "ra" })
+	i.Func("b", func() float64 { return 2 })
+	i.Func("c", func() bool { return true })
+	i.Func("collect", func(ctx context.Context) {
+		results, grouped = GroupResults(ctx)
+	})
+
+	t.Run("given a chain from a group", func(t *testing.T) {
+		err := Run(i, `(a()|b()|c()).collect();`)
+
+		require.NoError(t, err)
+
+		assert.True(t, grouped)
+		assert.Equal(t, []interface{}{"ra", float64(2), true}, results)
+	})
+
+	t.Run("given a chain from a function", func(t *testing.T) {
+		err := Run(i, `a().collect();`)
+
+		require.NoError(t, err)
+
+		assert.False(t, grouped)
+		assert.Nil(t, results)
+	})
+}
+
+func TestGroupProgramValue(t *testing.T) {
+	i := &Implementation{}
+	i.Func("a", func() string { return "ra" })
+	i.Func("b", func() float64 { return 2 })
+	i.Func("c", func() bool { return true })
+	i.Func("page", func() {})
+
+	t.Run("given a program that ends with a group", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "page();\n(a()|b()|c());")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{"ra", float64(2), true}, v)
+	})
+
+	t.Run("given a group followed by another statement", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "(a()|b()|c());\npage();")
+
+		require.NoError(t, err)
+
+		assert.Nil(t, v)
+	})
+
+	t.Run("given a group followed by a return", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "return a();\n(a()|b()|c());")
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "ra", v)
+	})
+
+	t.Run("given a group that is chained from", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), "(a()|b()|c()).page();")
+
+		require.NoError(t, err)
+
+		assert.Nil(t, v)
+	})
+}
+
+func TestGroupSpreadToVariadic(t *testing.T) {
+	var notified []string
+	var last interface{}
+
+	i := &Implementation{}
+	i.Func("a", func() string { return "ra" })
+	i.Func("b", func() string { return "rb" })
+	i.Func("notify", func(values ...string) {
+		notified = values
+	})
+	i.Func("prefixed", func(prefix string, values ...string) {
+		notified = append([]string{prefix}, values...)
+	})
+	i.Func("page", func(ctx context.Context) {
+		last = LastReturn(ctx)
+	})
+
+	t.Run("given a variadic function", func(t *testing.T) {
+		err := Run(i, `(a()|b()).notify();`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"ra", "rb"}, notified)
+	})
+
+	t.Run("given a variadic function with arguments", func(t *testing.T) {
+		err := Run(i, `(a()|b()).prefixed(team);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"team", "ra", "rb"}, notified)
+	})
+
+	t.Run("given a non-variadic function", func(t *testing.T) {
+		err := Run(i, `(a()|b()).page();`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{"ra", "rb"}, last)
+	})
+
+	t.Run("nested calls don't get the values", func(t *testing.T) {
+		err := Run(i, `(a()|b()).prefixed(format("{}" x));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"x", "ra", "rb"}, notified)
+	})
+}
+
+func TestMachineSetReadOnly(t *testing.T) {
+	m := NewSync(&Implementation{})
+	m.SetReadOnly(true)
+
+	t.Run("given an assignment", func(t *testing.T) {
+		err := exec(t, m, `const a = set(b);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ReadOnlyViolation> Attempting to assign 'a', but the machine is read-only")
+	})
+
+	t.Run("given a _delete", func(t *testing.T) {
+		err := exec(t, m, `_delete(a);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ReadOnlyViolation> Func _delete changes state, but the machine is read-only")
+	})
+
+	t.Run("given only function calls", func(t *testing.T) {
+		assert.NoError(t, exec(t, m, `set(format("{}" b));`))
+	})
+
+	t.Run("given read-only is disabled", func(t *testing.T) {
+		m.SetReadOnly(false)
+
+		assert.NoError(t, exec(t, m, "const a = set(b);\n_delete(a);"))
+	})
+}
+
+func TestRuntimeErrorProgramID(t *testing.T) {
+	prog, err := CompileSource(`fatal(boom);`)
+
+	require.NoError(t, err)
+
+	err = NewSync(&Implementation{}).Execute(prog)
+
+	require.Error(t, err)
+
+	rErr, ok := err.(*RuntimeError)
+	require.True(t, ok)
+
+	assert.Equal(t, prog.Id, rErr.ProgramID)
+
+	t.Run("given a preflight error", func(t *testing.T) {
+		prog, err := CompileSource(`missing();`)
+
+		require.NoError(t, err)
+
+		err = NewSync(&Implementation{}).Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, prog.Id, err.(*RuntimeError).ProgramID)
+	})
+}
+
+func TestMachineSetMaxHeapBytes(t *testing.T) {
+	m := NewSync(&Implementation{})
+	m.SetMaxHeapBytes(100)
+
+	t.Run("given strings that fit", func(t *testing.T) {
+		err := exec(t, m, "const a = \"0123456789\";\nconst b = $a + $a;\nconst c = $b + $b;")
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("given strings that grow past the limit", func(t *testing.T) {
+		err := exec(t, m, "const a = \"0123456789\";\nconst b = $a + $a;\nconst c = $b + $b;\nconst d = $c + $c;\nconst e = $d + $d;")
+
+		require.Error(t, err)
+
+		assert.Equal(t, "HeapBytesExceeded", err.(*RuntimeError).Code)
+		assert.Equal(t, "Runtime Error: <HeapBytesExceeded> Attempting to assign 80 bytes to 'd', but the heap is limited to 100 bytes and uses 70", err.Error())
+	})
+
+	t.Run("deleting a variable frees its bytes", func(t *testing.T) {
+		err := exec(t, m, "const a = \"0123456789\";\nconst b = $a + $a + $a + $a + $a + $a + $a + $a;\n_delete(b);\nconst c = $a + $a + $a + $a + $a + $a + $a + $a;")
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("given no limit", func(t *testing.T) {
+		m.SetMaxHeapBytes(0)
+		defer m.SetMaxHeapBytes(100)
+
+		err := exec(t, m, "const a = \"0123456789\";\nconst b = $a + $a;\nconst c = $b + $b;\nconst d = $c + $c;\nconst e = $d + $d;")
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestMachineSetMaxArgs(t *testing.T) {
+	m := NewSync(&Implementation{})
+	m.SetMaxArgs(3)
+
+	t.Run("given too many arguments", func(t *testing.T) {
+		err := exec(t, m, `format("{}{}{}" a b c);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<TooManyArguments> Attempting to call 'format' with 4 arguments. The maximum is 3")
+	})
+
+	t.Run("given the maximum arguments", func(t *testing.T) {
+		assert.NoError(t, exec(t, m, `format("{}{}" a b);`))
+	})
+
+	t.Run("given a group chained into a function that isn't variadic", func(t *testing.T) {
+		i := &Implementation{}
+		i.Func("a", func() string { return "a" })
+		i.Func("b", func() string { return "b" })
+		i.Func("page", func() {})
+
+		m := NewSync(i)
+		m.SetMaxArgs(2)
+
+		assert.NoError(t, exec(t, m, `(a()|b()|a()).page();`))
+	})
+
+	t.Run("given no maximum", func(t *testing.T) {
+		m.SetMaxArgs(0)
+
+		assert.NoError(t, exec(t, m, `format("{}{}{}" a b c);`))
+	})
+}
+
+func TestMachineSetLimitHook(t *testing.T) {
+	var events []LimitEvent
+
+	m := NewSync(&Implementation{})
+	m.SetLimitHook(func(ev LimitEvent) {
+		events = append(events, ev)
+	})
+
+	t.Run("given too many arguments", func(t *testing.T) {
+		events = nil
+
+		m.SetMaxArgs(3)
+		defer m.SetMaxArgs(0)
+
+		prog, err := CompileSource(`format("{}{}{}" a b c);`)
+
+		require.NoError(t, err)
+
+		err = m.Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "TooManyArguments", err.(*RuntimeError).Code)
+		assert.Equal(t, []LimitEvent{{Kind: LimitArgs, Limit: 3, ProgramID: prog.Id}}, events)
+	})
+
+	t.Run("given strings that grow past the heap limit", func(t *testing.T) {
+		events = nil
+
+		m.SetMaxHeapBytes(20)
+		defer m.SetMaxHeapBytes(0)
+
+		prog, err := CompileSource("const a = \"0123456789\";\nconst b = $a + $a;")
+
+		require.NoError(t, err)
+
+		err = m.Execute(prog)
+
+		require.Error(t, err)
+		assert.Equal(t, "HeapBytesExceeded", err.(*RuntimeError).Code)
+		assert.Equal(t, []LimitEvent{{Kind: LimitHeapBytes, Limit: 20, ProgramID: prog.Id}}, events)
+	})
+
+	t.Run("given a program within the limits", func(t *testing.T) {
+		events = nil
+
+		m.SetMaxArgs(3)
+		defer m.SetMaxArgs(0)
+
+		prog, err := CompileSource(`format("{}{}" a b);`)
+
+		require.NoError(t, err)
+
+		require.NoError(t, m.Execute(prog))
+		assert.Empty(t, events)
+	})
+}
+
+func TestMachineExecuteWithState(t *testing.T) {
+	var slacked []string
+
+	i := &Implementation{}
+	i.Func("warn", func(metric string) string { return "warn-" + metric })
+	i.Func("slack", func(channel string, id string) {
+		slacked = append(slacked, channel+" "+id)
+	})
+
+	m := NewSync(i)
+
+	first, err := CompileSource("const warnID = warn(cpu);")
+
+	require.NoError(t, err)
+	require.NoError(t, m.Execute(first))
+
+	state := m.State()
+
+	t.Run("given a program that reads the prior state", func(t *testing.T) {
+		prog, err := CompileSource("slack(#team $warnID);")
+
+		require.NoError(t, err)
+
+		err = m.ExecuteWithState(prog, state)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"#team warn-cpu"}, slacked)
+	})
+
+	t.Run("given a program that reassigns the prior state", func(t *testing.T) {
+		prog, err := CompileSource("const warnID = warn(mem);")
+
+		require.NoError(t, err)
+
+		err = m.ExecuteWithState(prog, state)
+
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "Attempting to reassign a value to a constant.")
+	})
+
+	t.Run("given a program that deletes the prior state", func(t *testing.T) {
+		prog, err := CompileSource("_delete(warnID);")
+
+		require.NoError(t, err)
+
+		err = m.ExecuteWithState(prog, state)
+
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "Attempting to delete 'warnID', which is from a prior run's state.")
+	})
+
+	t.Run("given a program run without the prior state", func(t *testing.T) {
+		prog, err := CompileSource("slack(#team $warnID);")
+
+		require.NoError(t, err)
+
+		assert.Error(t, m.Execute(prog))
+	})
+
+	t.Run("given a state that isn't from a machine", func(t *testing.T) {
+		prog, err := CompileSource("slack(#team $warnID);")
+
+		require.NoError(t, err)
+
+		assert.Error(t, m.ExecuteWithState(prog, nil))
+	})
+}
+
+func TestMachineIsBusy(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	i := &Implementation{}
+	i.Func("block", func() {
+		close(started)
+		<-release
+	})
+
+	m := New(i)
+	defer m.Shutdown()
+
+	prog, err := CompileSource("block();")
+
+	require.NoError(t, err)
+
+	assert.False(t, m.IsBusy())
+
+	done := make(chan error)
+	m.ExecuteAsync(prog, func(err error) { done <- err })
+
+	<-started
+
+	assert.True(t, m.IsBusy())
+
+	close(release)
+
+	require.NoError(t, <-done)
+
+	assert.False(t, m.IsBusy())
+}
+
+func TestEmptyProgram(t *testing.T) {
+	i := &Implementation{}
+	i.Func("page", func() {
+		t.Log("page called by a program without statements")
+		t.Fail()
+	})
+
+	sources := map[string]string{
+		"empty source":        "",
+		"whitespace source":   "  \n\t\n",
+		"comment-only source": "; Nothing to run yet.\n\n; page();\n",
+		"bare terminators":    ";\n  ;\n",
+		"explicit no-op":      "noop();\n; page();\nnoop();",
+	}
+
+	for name, src := range sources {
+		src := src
+
+		t.Run("given "+name, func(t *testing.T) {
+			prog, err := CompileSource(src)
+
+			require.NoError(t, err)
+
+			m := NewSync(i)
+
+			v, err := m.ExecuteValue(prog)
+
+			require.NoError(t, err)
+			assert.Nil(t, v)
+			assert.Equal(t, 0, m.State().StackDepth())
+		})
+	}
+}
+
+func TestMachineExportImportEnv(t *testing.T) {
+	src := NewSync(&Implementation{})
+	src.Setenv("app-name", "web")
+	src.Setenv("region", "us-east-1")
+
+	exported := src.ExportEnv()
+
+	assert.Equal(t, map[string]string{"app-name": "web", "region": "us-east-1"}, exported)
+
+	t.Run("the export is a copy", func(t *testing.T) {
+		exported := src.ExportEnv()
+		exported["region"] = "eu-west-1"
+
+		assert.Equal(t, "us-east-1", src.Getenv("region"))
+	})
+
+	t.Run("given an import that merges", func(t *testing.T) {
+		m := NewSync(&Implementation{})
+		m.Setenv("region", "eu-west-1")
+		m.Setenv("team", "ops")
+
+		m.ImportEnv(exported, false)
+
+		assert.Equal(t, map[string]string{"app-name": "web", "region": "us-east-1", "team": "ops"}, m.ExportEnv())
+	})
+
+	t.Run("given an import that replaces", func(t *testing.T) {
+		m := NewSync(&Implementation{})
+		m.Setenv("team", "ops")
+
+		m.ImportEnv(exported, true)
+
+		assert.Equal(t, exported, m.ExportEnv())
+
+		prog, err := CompileSource("return env(region);")
+
+		require.NoError(t, err)
+
+		v, err := m.ExecuteValue(prog)
+
+		require.NoError(t, err)
+		assert.Equal(t, "us-east-1", v)
+	})
+}
+
+func TestMachineSetStrictArity(t *testing.T) {
+	var paged []string
+
+	i := &Implementation{}
+	i.Func("page", func(team string) {
+		paged = append(paged, team)
+	})
+
+	prog, err := CompileSource("page(ops);\npage(ops dev);")
+
+	require.NoError(t, err)
+
+	t.Run("given strict arity is off", func(t *testing.T) {
+		paged = nil
+
+		err := NewSync(i).Execute(prog)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <ArgumentError> Attempting to call 'page' with 2 arguments. Expected 1", err.Error())
+		assert.Equal(t, []string{"ops"}, paged)
+	})
+
+	t.Run("given strict arity is on", func(t *testing.T) {
+		paged = nil
+
+		m := NewSync(i)
+		m.SetStrictArity(true)
+
+		err := m.Execute(prog)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Runtime Error: <ArgumentError> Attempting to call 'page' with 2 arguments. Expected 1", err.Error())
+		assert.Empty(t, paged)
+	})
+
+	t.Run("given strict arity is on and a named argument map", func(t *testing.T) {
+		i := &Implementation{}
+		i.FuncWithSignature("alert", []string{"metric", "value"}, func(metric string, value float64) {})
+
+		prog, err := CompileSource("alert(input(args));")
+
+		require.NoError(t, err)
+
+		m := NewSync(i)
+		m.SetInput(map[string]interface{}{"args": map[string]interface{}{"metric": "cpu", "value": float64(90)}})
+
+		require.NoError(t, m.Execute(prog))
+
+		m.SetStrictArity(true)
+
+		assert.Error(t, m.Execute(prog))
+	})
+}
+
+func TestMachineSetFrameHook(t *testing.T) {
+	i := &Implementation{}
+	i.Func("outer", func(v string) {})
+	i.Func("inner", func(v string) string { return v })
+
+	prog, err := CompileSource("outer(inner(x));")
+
+	require.NoError(t, err)
+
+	var events []string
+
+	m := NewSync(i)
+	m.SetFrameHook(func(depth int, event FrameEvent) {
+		events = append(events, fmt.Sprintf("%s %d", event, depth))
+	})
+
+	require.NoError(t, m.Execute(prog))
+
+	assert.Equal(t, []string{
+		"push 1", // ROOT
+		"push 2", // outer
+		"push 3", // inner
+		"push 4", // x
+		"pop 4",
+		"pop 3",
+		"pop 2",
+		"pop 1",
+	}, events)
+
+	t.Run("given the hook is removed", func(t *testing.T) {
+		events = nil
+
+		m.SetFrameHook(nil)
+
+		require.NoError(t, m.Execute(prog))
+
+		assert.Empty(t, events)
+	})
+}
+
+func TestMachineExecuteWithAllowed(t *testing.T) {
+	var called []string
+
+	i := &Implementation{}
+	i.Func("page", func(team string) { called = append(called, "page "+team) })
+	i.Func("scale-up", func(app string) { called = append(called, "scale-up "+app) })
+
+	m := NewSync(i)
+
+	t.Run("given only allowed functions", func(t *testing.T) {
+		called = nil
+
+		prog, err := CompileSource(`page(format("{}-team" ops));`)
+
+		require.NoError(t, err)
+
+		require.NoError(t, m.ExecuteWithAllowed(prog, []string{"page"}))
+
+		assert.Equal(t, []string{"page ops-team"}, called)
+	})
+
+	t.Run("given a function that isn't allowed", func(t *testing.T) {
+		called = nil
+
+		prog, err := CompileSource("page(ops);\nscale-up(web);\npage(dev);")
+
+		require.NoError(t, err)
+
+		err = m.ExecuteWithAllowed(prog, []string{"page"})
+
+		require.Error(t, err)
+
+		rErr, ok := err.(*RuntimeError)
+		require.True(t, ok)
+		assert.Equal(t, "FunctionNotAllowed", rErr.Code)
+		assert.Equal(t, "Attempting to call 'scale-up', which isn't allowed", rErr.Message)
+		assert.Equal(t, []string{"page ops"}, called)
+	})
+
+	t.Run("given a stdlib function that isn't pure", func(t *testing.T) {
+		prog, err := CompileSource("page(env(team));")
+
+		require.NoError(t, err)
+
+		err = m.ExecuteWithAllowed(prog, []string{"page"})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<FunctionNotAllowed> Attempting to call 'env', which isn't allowed")
+	})
+
+	t.Run("the allow-list only applies to its execution", func(t *testing.T) {
+		called = nil
+
+		prog, err := CompileSource("scale-up(web);")
+
+		require.NoError(t, err)
+
+		require.NoError(t, m.Execute(prog))
+
+		assert.Equal(t, []string{"scale-up web"}, called)
+	})
+}
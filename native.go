@@ -1,14 +1,45 @@
 package machine
 
+import "unicode"
+
 var (
 	nativeFunctionNames = []string{
 		"_delete",
 	}
 
+	// Native functions that change the state of the running program. They can't be called by a read-only machine.
+	mutatingNativeNames = []string{
+		"_delete",
+	}
+
+	// Stdlib functions that always return the same value for the same arguments and have no side effects.
+	pureFunctionNames = []string{
+		"set",
+		"setf",
+		"format",
+		"coalesce",
+		"contains",
+		"starts_with",
+		"ends_with",
+		"json_get",
+		"split",
+		"join",
+		"deep_equal",
+		"between",
+		"clamp",
+		"round",
+		"floor",
+		"ceil",
+		"first",
+		"last",
+	}
+
 	reservedWords = []string{
 		"const",
 		"true",
 		"false",
+		"return",
+		"defer",
 	}
 )
 
@@ -20,3 +51,30 @@ func contains(set []string, word string) bool {
 	}
 	return false
 }
+
+// Checks that the word can be used as a name. It must start with a letter or _ and only contain letters, digits, _,
+// and -.
+func isIdentifier(word string) bool {
+	for i, r := range word {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-'):
+		default:
+			return false
+		}
+	}
+	return word != ""
+}
+
+// Checks that the word is a bare integer number, with an optional leading -.
+func isNumber(word string) bool {
+	for i, r := range word {
+		switch {
+		case r >= '0' && r <= '9':
+		case i == 0 && r == '-' && len(word) > 1:
+		default:
+			return false
+		}
+	}
+	return word != ""
+}
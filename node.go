@@ -3,6 +3,9 @@ package machine
 import (
 	"fmt"
 	"reflect"
+	"sort"
+
+	proto "github.com/golang/protobuf/proto"
 )
 
 // NodeCompare compares all nodes. The ID can be different, but all sub-values must be the same.
@@ -44,6 +47,197 @@ func nCompare(lhs, rhs *NodeIL, d int) bool {
 	return true
 }
 
+// Walk calls the function for the node and every node below it, parents before children. Children are visited
+// before the chained node. If the function returns false the nodes below that node are skipped.
+func Walk(n *NodeIL, fn func(*NodeIL) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+
+	for _, c := range n.Children {
+		Walk(c, fn)
+	}
+
+	Walk(n.Chained, fn)
+}
+
+// ChainDepth returns the number of nodes in the chain that starts at the node, following each Chained node. A node
+// that isn't chained to anything has a depth of 1, and a nil node has a depth of 0.
+//
+// A chain that loops back on itself stops at the first node that's repeated.
+func ChainDepth(n *NodeIL) int {
+	seen := map[*NodeIL]bool{}
+
+	depth := 0
+	for ; n != nil && !seen[n]; n = n.Chained {
+		seen[n] = true
+		depth++
+	}
+
+	return depth
+}
+
+// Returns a node that can reach itself through its children or chained nodes, or nil if there isn't a cycle.
+//
+// A node can be reached more than once, as long as it isn't below itself.
+func findCycle(root *NodeIL) *NodeIL {
+	path := map[*NodeIL]bool{}
+	done := map[*NodeIL]bool{}
+
+	var visit func(*NodeIL) *NodeIL
+	visit = func(n *NodeIL) *NodeIL {
+		if n == nil || done[n] {
+			return nil
+		}
+		if path[n] {
+			return n
+		}
+
+		path[n] = true
+		for _, c := range n.Children {
+			if found := visit(c); found != nil {
+				return found
+			}
+		}
+		if found := visit(n.Chained); found != nil {
+			return found
+		}
+		delete(path, n)
+		done[n] = true
+
+		return nil
+	}
+
+	return visit(root)
+}
+
+// Clone returns a deep copy of the node and every node below it.
+func Clone(n *NodeIL) *NodeIL {
+	if n == nil {
+		return nil
+	}
+	return proto.Clone(n).(*NodeIL)
+}
+
+// Returns the number of nodes in the tree, including chained nodes.
+func countNodes(n *NodeIL) int {
+	count := 0
+
+	Walk(n, func(*NodeIL) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
+// ProgramComplexity contains the size and shape of a program.
+type ProgramComplexity struct {
+	// The number of nodes in the program, including chained nodes.
+	Nodes int
+
+	// The number of nodes of each kind.
+	Kinds map[NodeIL_Kind]int
+
+	// The number of nodes on the longest path from the entry node, through children and chained nodes.
+	MaxDepth int
+
+	// The longest run of chained nodes.
+	MaxChain int
+}
+
+// Complexity returns the size and shape of the program.
+func (p *ProgramIL) Complexity() ProgramComplexity {
+	c := ProgramComplexity{
+		Kinds: map[NodeIL_Kind]int{},
+	}
+
+	Walk(p.Entry, func(n *NodeIL) bool {
+		c.Nodes++
+		c.Kinds[n.Kind]++
+		return true
+	})
+
+	var measure func(n *NodeIL, depth int, chain int)
+	measure = func(n *NodeIL, depth int, chain int) {
+		if n == nil {
+			return
+		}
+		if depth > c.MaxDepth {
+			c.MaxDepth = depth
+		}
+		if chain > c.MaxChain {
+			c.MaxChain = chain
+		}
+		for _, child := range n.Children {
+			measure(child, depth+1, 0)
+		}
+		measure(n.Chained, depth+1, chain+1)
+	}
+	measure(p.Entry, 1, 0)
+
+	return c
+}
+
+// EnvDependencies returns the names of the environment variables the program reads with `env`, sorted.
+//
+// Only names passed as a value are found. A name from a function call or variable isn't known until the program is run,
+// so it's skipped.
+func (p *ProgramIL) EnvDependencies() []string {
+	seen := map[string]bool{}
+	names := []string{}
+
+	Walk(p.Entry, func(n *NodeIL) bool {
+		if n.Kind != NodeIL_FUNC || n.Value == nil || n.Value.Str != "env" || len(n.Children) == 0 {
+			return true
+		}
+
+		arg := n.Children[0]
+		if arg.Kind != NodeIL_VALUE || arg.Value == nil || arg.Value.Kind != NodeIL_DValue_STR {
+			return true
+		}
+
+		if !seen[arg.Value.Str] {
+			seen[arg.Value.Str] = true
+			names = append(names, arg.Value.Str)
+		}
+
+		return true
+	})
+
+	sort.Strings(names)
+
+	return names
+}
+
+// StringLiterals returns every string value in the program, in the order they appear. Each string is only returned
+// once.
+//
+// Values passed as arguments, assigned to a variable, and used as a match case are included. Function and variable
+// names aren't.
+func (p *ProgramIL) StringLiterals() []string {
+	seen := map[string]bool{}
+	literals := []string{}
+
+	Walk(p.Entry, func(n *NodeIL) bool {
+		if n.Kind != NodeIL_VALUE && n.Kind != NodeIL_CASE {
+			return true
+		}
+		if n.Value == nil || n.Value.Kind != NodeIL_DValue_STR {
+			return true
+		}
+
+		if !seen[n.Value.Str] {
+			seen[n.Value.Str] = true
+			literals = append(literals, n.Value.Str)
+		}
+
+		return true
+	})
+
+	return literals
+}
+
 func nCompareV(lhs, rhs *NodeIL_DValue) bool {
 	if lhs == nil && rhs == nil {
 		return true
@@ -0,0 +1,52 @@
+package machine
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// The operators a BINARY node can have.
+var binaryOperators = []string{"+"}
+
+// Adds two values. Two strings are joined, and two numbers are added.
+//
+// Mixing a string and a number is an error. Use `format` to build a string from a number.
+func add(lhs, rhs reflect.Value) (reflect.Value, error) {
+	l, r := unwrap(lhs), unwrap(rhs)
+
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return reflect.ValueOf(ls + rs), nil
+		}
+	}
+
+	if lf, ok := number(l); ok {
+		if rf, ok := number(r); ok {
+			return reflect.ValueOf(lf + rf), nil
+		}
+	}
+
+	return reflect.Value{}, &RuntimeError{
+		Code:    "TypeError",
+		Message: fmt.Sprintf("Attempting to add %s and %s. Both values must be strings or numbers.", renderTyped(lhs), renderTyped(rhs)),
+	}
+}
+
+// Returns the value as a float64 if it's any kind of number.
+func number(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
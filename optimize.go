@@ -0,0 +1,76 @@
+package machine
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// fold walks the tree and replaces every call to a pure stdlib function that only has constant arguments with the
+// value returned by the function.
+func (c *compiler) fold(n *NodeIL) *NodeIL {
+	if n == nil {
+		return nil
+	}
+
+	for i, child := range n.Children {
+		n.Children[i] = c.fold(child)
+	}
+
+	// A call chained from a group gets the group's values as arguments when it's run, so only its arguments are folded.
+	if n.Kind == NodeIL_GROUP && n.Chained != nil {
+		for i, child := range n.Chained.Children {
+			n.Chained.Children[i] = c.fold(child)
+		}
+		n.Chained.Chained = c.fold(n.Chained.Chained)
+	} else {
+		n.Chained = c.fold(n.Chained)
+	}
+
+	if n.Kind != NodeIL_FUNC || n.Chained != nil || !contains(pureFunctionNames, n.Value.Str) {
+		return n
+	}
+
+	args := make([]reflect.Value, 0, len(n.Children))
+	for _, child := range n.Children {
+		if child.Kind != NodeIL_VALUE || child.Value == nil {
+			return n
+		}
+		// A string may be interpolated when the program is run.
+		if child.Value.Kind == NodeIL_DValue_STR && strings.Contains(child.Value.Str, "${") {
+			return n
+		}
+		args = append(args, child.Value.value())
+	}
+
+	fn, err := stdlib().lookup(n.Value.Str)
+	if err != nil || fn.recCxt || !fn.arity(len(args)) {
+		return n
+	}
+	for i, arg := range args {
+		if !arg.Type().AssignableTo(fn.in(i)) {
+			return n
+		}
+	}
+
+	// Anything that fails to evaluate is left alone so the error is raised when the program is run.
+	ret, err := fn.call(context.Background(), args, nil)
+	if err != nil || !ret.IsValid() {
+		return n
+	}
+
+	new := newNode(NodeIL_VALUE)
+	switch v := ret.Interface().(type) {
+	case string, float64, bool:
+		new.setValue(v)
+	default:
+		return n
+	}
+
+	c.FuncCalls[n.Value.Str]--
+	if c.FuncCalls[n.Value.Str] == 0 {
+		delete(c.FuncCalls, n.Value.Str)
+	}
+
+	return new
+}
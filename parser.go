@@ -29,12 +29,13 @@ func parser(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 
 	for i := 0; i < len(tokens); {
 		in := parseTokenInput{
-			compiler: comp,
-			node:     node,
-			token:    tokens[i],
-			before:   tokens[:i],
-			after:    tokens[i+1:],
-			depth:    0,
+			compiler:  comp,
+			node:      node,
+			token:     tokens[i],
+			before:    tokens[:i],
+			after:     tokens[i+1:],
+			depth:     0,
+			statement: true,
 		}
 
 		c, _ := parseToken(ctx, fail, in)
@@ -52,6 +53,9 @@ type parseTokenInput struct {
 	before   []*TokenIL
 	after    []*TokenIL
 	depth    int
+
+	// The node runs statements, like the program's root or a match case, so it can have a `return` or `defer`.
+	statement bool
 }
 
 func (i *parseTokenInput) prev() (*TokenIL, bool) {
@@ -68,6 +72,15 @@ func (i *parseTokenInput) next() (*TokenIL, bool) {
 	return i.after[0], true
 }
 
+func (i *parseTokenInput) startsStatement() bool {
+	prev, ok := i.prev()
+	if !ok || prev.Kind == TokenIL_END || prev.Kind == TokenIL_RBRACE {
+		return true
+	}
+	// A match case's statement follows its `:`
+	return i.statement && prev.Kind == TokenIL_COLON
+}
+
 func (i *parseTokenInput) nextIsProbablyFunc() bool {
 	if len(i.after) < 2 {
 		return false
@@ -75,6 +88,53 @@ func (i *parseTokenInput) nextIsProbablyFunc() bool {
 	return i.after[0].Kind == TokenIL_VALUE && i.after[1].Kind == TokenIL_OPEN
 }
 
+func (i *parseTokenInput) nextIsProbablyAssign() bool {
+	if len(i.after) > 0 && i.after[0].Kind == TokenIL_ASSIGN {
+		return true
+	}
+	return len(i.after) > 1 && i.after[0].Kind == TokenIL_VALUE && i.after[1].Kind == TokenIL_ASSIGN
+}
+
+func (i *parseTokenInput) nextIsBareValue() bool {
+	next, ok := i.next()
+	if !ok {
+		return false
+	}
+
+	switch next.Kind {
+	case TokenIL_STRING, TokenIL_VAR:
+		return true
+	case TokenIL_VALUE:
+		return len(i.after) < 2 || i.after[1].Kind != TokenIL_OPEN
+	default:
+		return false
+	}
+}
+
+// Checks for the parens around a match's subject followed by a `{`.
+func (i *parseTokenInput) nextIsProbablyMatch() bool {
+	if len(i.after) == 0 || i.after[0].Kind != TokenIL_OPEN {
+		return false
+	}
+
+	depth := 0
+	for n, t := range i.after {
+		switch t.Kind {
+		case TokenIL_OPEN:
+			depth++
+		case TokenIL_CLOSE:
+			depth--
+		case TokenIL_END:
+			return false
+		}
+		if depth == 0 {
+			return len(i.after) > n+1 && i.after[n+1].Kind == TokenIL_LBRACE
+		}
+	}
+
+	return false
+}
+
 func (i *parseTokenInput) nextIsProbablyGroup() bool {
 	if len(i.after) < 3 {
 		return false
@@ -94,6 +154,13 @@ func (i *parseTokenInput) syntax(m string) *SyntaxError {
 //
 // Returns the number of tokens consumed, and if the the calling node should close.
 func parseToken(ctx context.Context, fail failable.FailFunc, input parseTokenInput) (int, bool) {
+	if err := ctx.Err(); err != nil {
+		fail(err)
+	}
+	if input.depth > input.compiler.maxDepth() {
+		fail(input.syntax(fmt.Sprintf("Maximum nesting depth of %d exceeded.", input.compiler.maxDepth())))
+	}
+
 	switch input.token.Kind {
 	case TokenIL_VALUE:
 		return parseValueToken(ctx, input, fail)
@@ -111,6 +178,18 @@ func parseToken(ctx context.Context, fail failable.FailFunc, input parseTokenInp
 		return parseAssignToken(ctx, input, fail)
 	case TokenIL_VAR:
 		return parseVarToken(ctx, input, fail)
+	case TokenIL_STRING:
+		return parseStringToken(ctx, input, fail)
+	case TokenIL_QUESTION:
+		return parseQuestionToken(ctx, input, fail)
+	case TokenIL_PLUS:
+		return parsePlusToken(ctx, input, fail)
+	case TokenIL_COLON:
+		fail(input.syntax("Unexpected :. Expected a ? or a match case before it."))
+		panic("WTF... this should never happen")
+	case TokenIL_LBRACE, TokenIL_RBRACE:
+		fail(input.syntax("Unexpected brace. Braces can only be used by a match."))
+		panic("WTF... this should never happen")
 	default:
 		panic(fmt.Sprintf("Unknown token: %+v", input.token))
 	}
@@ -138,7 +217,7 @@ func parseDotToken(ctx context.Context, in parseTokenInput, fail failable.FailFu
 				compiler: in.compiler,
 				node:     new,
 				token:    in.after[i],
-				before:   append(in.before, in.after[:i]...),
+				before:   append(append(in.before, in.token), in.after[:i]...),
 				after:    in.after[i+1:],
 				depth:    (in.depth + 1),
 			}
@@ -166,7 +245,26 @@ func parseDotToken(ctx context.Context, in parseTokenInput, fail failable.FailFu
 			fail(in.syntax(fmt.Sprintf("Expected to find a value after .")))
 		}
 
-		if strings.HasPrefix(last.Value.Str, "f") {
+		// A bare number followed by a dot is the whole part of a decimal number.
+		if last.Value.Kind == NodeIL_DValue_FLT && len(in.before) > 0 {
+			whole := in.before[len(in.before)-1]
+			if len(in.before) > 1 && in.before[len(in.before)-2].Kind == TokenIL_DOT || !isNumber(whole.Value) {
+				fail(in.syntax(fmt.Sprintf("Unexpected . after the number %s", whole.Value)))
+			}
+
+			raw := fmt.Sprintf("%s.%s", whole.Value, next.Value)
+			flt, err := strconv.ParseFloat(raw, 64)
+
+			if err != nil {
+				fail(in.syntax(fmt.Sprintf("Invalid float value: %v", err)))
+			}
+
+			last.setValue(flt)
+
+			return 2, false
+		}
+
+		if last.Value.Kind == NodeIL_DValue_STR && strings.HasPrefix(last.Value.Str, "f") {
 			raw := fmt.Sprintf("%s.%s", last.Value.Str[1:], next.Value)
 			flt, err := strconv.ParseFloat(raw, 64)
 
@@ -195,8 +293,14 @@ func parseAssignToken(ctx context.Context, in parseTokenInput, fail failable.Fai
 		fail(in.syntax("Unexpected assignment. Nothing to assign to the variable."))
 	}
 
-	kind := in.before[len(in.before)-2]
-	name := in.before[len(in.before)-1]
+	// The names are every value between the type and the `=`. More than one name is separated by commas.
+	k := len(in.before) - 2
+	for k > 0 && in.before[k].Kind == TokenIL_VALUE && in.before[k].Value != "const" {
+		k--
+	}
+
+	kind := in.before[k]
+	name := in.before[k+1]
 
 	if kind.Kind != TokenIL_VALUE || kind.Value != "const" {
 		fail(&SyntaxError{
@@ -214,20 +318,72 @@ func parseAssignToken(ctx context.Context, in parseTokenInput, fail failable.Fai
 		})
 	}
 
+	list := []string{}
+	for _, t := range in.before[k+1:] {
+		list = append(list, t.Value)
+	}
+
+	names := strings.Split(strings.Join(list, " "), ",")
+	for n, v := range names {
+		names[n] = strings.TrimSpace(v)
+
+		if !isIdentifier(names[n]) || contains(reservedWords, names[n]) {
+			fail(&SyntaxError{
+				Token:   name,
+				Node:    in.node,
+				Message: fmt.Sprintf("'%s' is not a valid variable name. Names start with a letter or _ and can't be a reserved word.", names[n]),
+			})
+		}
+	}
+
+	if len(names) > 1 && in.node.Kind == NodeIL_FUNC {
+		fail(in.syntax("Unexpected assignment. Assigning more than one name can't be used as an argument."))
+	}
+
 	new := newNode(NodeIL_ASSIGN)
 	new.SubType = kind.Value
-	new.setValue(name.Value)
+	new.setValue(strings.Join(names, ",")) // Names can't have a comma, so more than one name is joined by commas.
+
+	// An assignment used as a function argument only consumes a single expression, and doesn't close the function.
+	inline := in.node.Kind == NodeIL_FUNC
+
+	// A bare value, string, or variable is assigned directly instead of from a call.
+	if in.nextIsBareValue() {
+		value, consumed := parseExpression(ctx, in, 0, fail)
+		consumed++
+
+		if !inline && len(in.after) >= consumed {
+			if end := in.after[consumed-1]; end.Kind != TokenIL_END {
+				fail(&SyntaxError{
+					Token:   end,
+					Node:    in.node,
+					Message: "Unexpected token after the assigned value. Expected a `;`.",
+				})
+			}
+			consumed++
+		}
+
+		new.Chained = value
+
+		in.node.addChild(new)
+
+		return consumed, !inline
+	}
 
 	root := newNode(NodeIL_ROOT)
 
 	consumed := 1
 
 	for i := 0; i < len(in.after); {
+		if inline && len(root.Children) == 1 && !continues(in.after[i].Kind, []TokenIL_Kind{TokenIL_DOT, TokenIL_QUESTION, TokenIL_PLUS}) {
+			break
+		}
+
 		in := parseTokenInput{
 			compiler: in.compiler,
 			node:     root,
 			token:    in.after[i],
-			before:   append(in.before, in.after[:i]...),
+			before:   append(append(in.before, in.token), in.after[:i]...),
 			after:    in.after[i+1:],
 			depth:    (in.depth + 1),
 		}
@@ -250,7 +406,7 @@ func parseAssignToken(ctx context.Context, in parseTokenInput, fail failable.Fai
 
 	in.node.addChild(new)
 
-	return consumed, true
+	return consumed, !inline
 }
 
 func parseOpenToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
@@ -266,7 +422,7 @@ func parseOpenToken(ctx context.Context, in parseTokenInput, fail failable.FailF
 	case NodeIL_ROOT, NodeIL_GROUP:
 		var new *NodeIL
 
-		if prev, ok := in.prev(); !ok || prev.Kind != TokenIL_VALUE {
+		if prev, ok := in.prev(); !ok || prev.Kind != TokenIL_VALUE || contains(reservedWords, prev.Value) {
 			new = newNode(NodeIL_GROUP)
 		} else {
 			prev, _ := in.prev()
@@ -288,7 +444,7 @@ func parseOpenToken(ctx context.Context, in parseTokenInput, fail failable.FailF
 				compiler: in.compiler,
 				node:     new,
 				token:    in.after[i],
-				before:   append(in.before, in.after[:i]...),
+				before:   append(append(in.before, in.token), in.after[:i]...),
 				after:    in.after[i+1:],
 				depth:    (in.depth + 1),
 			}
@@ -303,6 +459,14 @@ func parseOpenToken(ctx context.Context, in parseTokenInput, fail failable.FailF
 			}
 		}
 
+		// Extra parens around a single argument don't change it. `foo((bar()))` is the same as `foo(bar())`.
+		// A group that is chained from is kept, because the chain gets the group's slice of return values.
+		chained := len(in.after) >= consumed && in.after[consumed-1].Kind == TokenIL_DOT
+		redundant := node.Kind == NodeIL_FUNC || node.Kind == NodeIL_GROUP
+		if new.Kind == NodeIL_GROUP && redundant && len(new.Children) == 1 && !chained {
+			node.Children[len(node.Children)-1] = new.Children[0]
+		}
+
 		return consumed, false
 	default:
 		fail(in.syntax("Unexpected Open. The open is not not in a valid context."))
@@ -333,7 +497,19 @@ func parsePipeToken(_ context.Context, in parseTokenInput, fail failable.FailFun
 	return 1, false
 }
 
-func parseValueToken(_ context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+func parseValueToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	if in.statement && in.token.Value == "return" && in.startsStatement() {
+		return parseReturnToken(ctx, in, fail)
+	}
+
+	if in.statement && in.token.Value == "defer" && in.startsStatement() {
+		return parseDeferToken(ctx, in, fail)
+	}
+
+	if in.node.Kind == NodeIL_ROOT && in.token.Value == "match" && in.startsStatement() && in.nextIsProbablyMatch() {
+		return parseMatchToken(ctx, in, fail)
+	}
+
 	if in.node.Kind != NodeIL_FUNC && in.node.Kind != NodeIL_NAT {
 		return 1, false // Something else will backtrack and consume this soon.
 	}
@@ -342,12 +518,22 @@ func parseValueToken(_ context.Context, in parseTokenInput, fail failable.FailFu
 		return 1, false // This is probably a nested function call.
 	}
 
+	if in.nextIsProbablyAssign() {
+		return 1, false // This is the type or name of an inline assignment.
+	}
+
 	new := newNode(NodeIL_VALUE)
-	switch in.token.Value {
-	case "true":
+	switch {
+	case in.token.Value == "true":
 		new.setValue(true)
-	case "false":
+	case in.token.Value == "false":
 		new.setValue(false)
+	case isNumber(in.token.Value):
+		flt, err := strconv.ParseFloat(in.token.Value, 64)
+		if err != nil {
+			fail(in.syntax(fmt.Sprintf("Invalid number value: %v", err)))
+		}
+		new.setValue(flt)
 	default:
 		new.setValue(in.token.Value)
 	}
@@ -357,6 +543,305 @@ func parseValueToken(_ context.Context, in parseTokenInput, fail failable.FailFu
 	return 1, false
 }
 
+func parseStringToken(_ context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	if in.node.Kind != NodeIL_FUNC && in.node.Kind != NodeIL_NAT {
+		fail(in.syntax("Unexpected string. A string can only be used as an argument."))
+	}
+
+	new := newNode(NodeIL_VALUE)
+	new.setValue(in.token.Value)
+
+	in.node.addChild(new)
+
+	return 1, false
+}
+
+func parseReturnToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	new := newNode(NodeIL_RETURN)
+
+	root := newNode(NodeIL_ROOT)
+
+	consumed := 1
+
+	for i := 0; i < len(in.after); {
+		in := parseTokenInput{
+			compiler: in.compiler,
+			node:     root,
+			token:    in.after[i],
+			before:   append(append(in.before, in.token), in.after[:i]...),
+			after:    in.after[i+1:],
+			depth:    (in.depth + 1),
+		}
+
+		c, d := parseToken(ctx, fail, in)
+
+		consumed += c
+		i += c
+
+		if d {
+			break
+		}
+	}
+
+	switch len(root.Children) {
+	case 0:
+		if consumed > 2 { // More than the return and the end of the statement.
+			fail(in.syntax("Unexpected return. Expected a function call or group to return the value from."))
+		}
+	case 1:
+		new.Chained = root.Children[0]
+	default:
+		fail(in.syntax(fmt.Sprintf("Failed to return. Only expected one root child. Got %d", len(root.Children))))
+	}
+
+	in.node.addChild(new)
+
+	return consumed, true
+}
+
+func parseDeferToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	new := newNode(NodeIL_DEFER)
+
+	root := newNode(NodeIL_ROOT)
+
+	consumed := 1
+
+	for i := 0; i < len(in.after); {
+		in := parseTokenInput{
+			compiler: in.compiler,
+			node:     root,
+			token:    in.after[i],
+			before:   append(append(in.before, in.token), in.after[:i]...),
+			after:    in.after[i+1:],
+			depth:    (in.depth + 1),
+		}
+
+		c, d := parseToken(ctx, fail, in)
+
+		consumed += c
+		i += c
+
+		if d {
+			break
+		}
+	}
+
+	if len(root.Children) != 1 {
+		fail(in.syntax(fmt.Sprintf("Unexpected defer. Expected a single function call or group to run later. Got %d", len(root.Children))))
+	}
+
+	switch root.Children[0].Kind {
+	case NodeIL_FUNC, NodeIL_GROUP, NodeIL_NAT:
+		new.Chained = root.Children[0]
+	default:
+		fail(in.syntax("Unexpected defer. Only a function call or group can be run later."))
+	}
+
+	in.node.addChild(new)
+
+	return consumed, true
+}
+
+func parseQuestionToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	if in.node.Kind != NodeIL_FUNC && in.node.Kind != NodeIL_ROOT {
+		fail(in.syntax("Unexpected ?. A condition can only be used as an argument or a statement."))
+	}
+	if len(in.node.Children) == 0 {
+		fail(in.syntax("Unexpected ?. Expected a condition before it."))
+	}
+
+	// The condition has already been parsed as the last child.
+	cond := in.node.Children[len(in.node.Children)-1]
+	in.node.Children = in.node.Children[:len(in.node.Children)-1]
+
+	consumed := 1
+
+	then, c := parseExpression(ctx, in, consumed-1, fail)
+	consumed += c
+
+	if len(in.after) < consumed || in.after[consumed-1].Kind != TokenIL_COLON {
+		fail(in.syntax("Unexpected ?. Expected a : after the value when true."))
+	}
+	consumed++
+
+	els, c := parseExpression(ctx, in, consumed-1, fail)
+	consumed += c
+
+	new := newNode(NodeIL_TERNARY)
+	new.addChild(cond, then, els)
+
+	in.node.addChild(new)
+
+	return consumed, false
+}
+
+func parsePlusToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	if in.node.Kind != NodeIL_FUNC && in.node.Kind != NodeIL_ROOT {
+		fail(in.syntax("Unexpected +. An operator can only be used as an argument or a statement."))
+	}
+	if len(in.node.Children) == 0 {
+		fail(in.syntax("Unexpected +. Expected a value before it."))
+	}
+
+	// The left value has already been parsed as the last child.
+	lhs := in.node.Children[len(in.node.Children)-1]
+	in.node.Children = in.node.Children[:len(in.node.Children)-1]
+
+	// The right value doesn't continue through a `?` or another `+`, so the operator binds tighter than a condition and
+	// more than one `+` is added from the left.
+	rhs, consumed := parseOperand(ctx, in, 0, fail)
+
+	new := newNode(NodeIL_BINARY)
+	new.setValue("+")
+	new.addChild(lhs, rhs)
+
+	in.node.addChild(new)
+
+	return consumed + 1, false
+}
+
+func parseMatchToken(ctx context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
+	expect := func(i int, k TokenIL_Kind, m string) {
+		if i >= len(in.after) {
+			fail(in.syntax(fmt.Sprintf("Unexpected EOF. %s", m)))
+		}
+		if in.after[i].Kind != k {
+			fail(&SyntaxError{Token: in.after[i], Node: in.node, Message: fmt.Sprintf("Unexpected token. %s", m)})
+		}
+	}
+
+	new := newNode(NodeIL_MATCH)
+
+	// The subject is the single expression in the parens.
+	subject, c := parseExpression(ctx, in, 1, fail)
+	new.addChild(subject)
+
+	i := 1 + c
+	expect(i, TokenIL_CLOSE, "Expected a ) after the match value.")
+	i++
+	expect(i, TokenIL_LBRACE, "Expected a { after the match value.")
+	i++
+
+	for {
+		if i >= len(in.after) {
+			fail(in.syntax("Unexpected EOF. The match is missing a closing }."))
+		}
+		if in.after[i].Kind == TokenIL_RBRACE {
+			i++
+			break
+		}
+
+		kase := newNode(NodeIL_CASE)
+
+		if t := in.after[i]; t.Kind == TokenIL_VALUE && t.Value == "default" {
+			kase.SubType = "default"
+			i++
+		} else {
+			label, c := parseExpression(ctx, in, i, fail)
+			if label.Kind != NodeIL_VALUE {
+				fail(&SyntaxError{Token: t, Node: new, Message: "A match case must be a string, number, or bool."})
+			}
+			kase.Value = label.Value
+			i += c
+		}
+
+		expect(i, TokenIL_COLON, "Expected a : after the match case.")
+		i++
+
+		// The case runs a single statement.
+		root := newNode(NodeIL_ROOT)
+
+		for i < len(in.after) {
+			in := parseTokenInput{
+				compiler:  in.compiler,
+				node:      root,
+				token:     in.after[i],
+				before:    append(append(in.before, in.token), in.after[:i]...),
+				after:     in.after[i+1:],
+				depth:     (in.depth + 1),
+				statement: true,
+			}
+
+			c, d := parseToken(ctx, fail, in)
+
+			i += c
+
+			if d {
+				break
+			}
+		}
+
+		kase.addChild(root.Children...)
+		new.addChild(kase)
+	}
+
+	// The match can be followed by a `;`
+	if i < len(in.after) && in.after[i].Kind == TokenIL_END {
+		i++
+	}
+
+	in.node.addChild(new)
+
+	return i + 1, true
+}
+
+// Parses a single argument starting at the index of the remaining tokens. Returns the node, and the number of tokens
+// consumed.
+func parseExpression(ctx context.Context, in parseTokenInput, start int, fail failable.FailFunc) (*NodeIL, int) {
+	return parseSingle(ctx, in, start, fail, TokenIL_DOT, TokenIL_QUESTION, TokenIL_PLUS)
+}
+
+// Parses a single value for an operator, only continuing through a chain.
+func parseOperand(ctx context.Context, in parseTokenInput, start int, fail failable.FailFunc) (*NodeIL, int) {
+	return parseSingle(ctx, in, start, fail, TokenIL_DOT)
+}
+
+// Parses a single value, continuing while the next token is one of the continuing kinds.
+func parseSingle(ctx context.Context, in parseTokenInput, start int, fail failable.FailFunc, continuing ...TokenIL_Kind) (*NodeIL, int) {
+	holder := newNode(NodeIL_FUNC)
+
+	consumed := 0
+
+	for i := start; i < len(in.after); {
+		if len(holder.Children) == 1 && !continues(in.after[i].Kind, continuing) {
+			break
+		}
+
+		in := parseTokenInput{
+			compiler: in.compiler,
+			node:     holder,
+			token:    in.after[i],
+			before:   append(append(in.before, in.token), in.after[:i]...),
+			after:    in.after[i+1:],
+			depth:    (in.depth + 1),
+		}
+
+		c, d := parseToken(ctx, fail, in)
+
+		consumed += c
+		i += c
+
+		if d {
+			break
+		}
+	}
+
+	if len(holder.Children) != 1 {
+		fail(in.syntax(fmt.Sprintf("Expected a single value. Got %d", len(holder.Children))))
+	}
+
+	return holder.Children[0], consumed
+}
+
+func continues(k TokenIL_Kind, continuing []TokenIL_Kind) bool {
+	for _, c := range continuing {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
+
 func parseVarToken(_ context.Context, in parseTokenInput, fail failable.FailFunc) (int, bool) {
 	next, ok := in.next()
 
@@ -1 +1,119 @@
 package machine_test
+
+import (
+	"testing"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAssignmentName(t *testing.T) {
+	invalid := map[string]string{
+		"a number":        `const 5 = env(foo);`,
+		"a bool":          `const true = env(foo);`,
+		"a reserved word": `const return = env(foo);`,
+	}
+
+	for name, src := range invalid {
+		t.Run("given "+name, func(t *testing.T) {
+			_, err := CompileSource(src)
+
+			require.Error(t, err)
+
+			sErr, ok := err.(*SyntaxError)
+
+			require.True(t, ok)
+
+			assert.Contains(t, sErr.Message, "is not a valid variable name")
+			assert.Equal(t, uint32(7), sErr.Token.Column)
+		})
+	}
+
+	t.Run("given a valid name", func(t *testing.T) {
+		_, err := CompileSource(`const app-name_2 = env(foo);`)
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestParseNumbers(t *testing.T) {
+	arg := func(t *testing.T, src string) *NodeIL_DValue {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+		require.Len(t, prog.Entry.Children[0].Children, 1)
+
+		return prog.Entry.Children[0].Children[0].Value
+	}
+
+	t.Run("a bare integer is a number", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_FLT, Flt: 42}, arg(t, `foo(42);`))
+	})
+
+	t.Run("a bare decimal is a number", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_FLT, Flt: -1.5}, arg(t, `foo(-1.5);`))
+	})
+
+	t.Run("a prefixed float is a number", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_FLT, Flt: 1.5}, arg(t, `foo(f1.5);`))
+	})
+
+	t.Run("a quoted number is a string", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "42"}, arg(t, `foo("42");`))
+	})
+
+	t.Run("a value that starts with a number is a string", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "42s"}, arg(t, `foo(42s);`))
+	})
+
+	t.Run("digits that aren't ASCII are a string", func(t *testing.T) {
+		assert.Equal(t, &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "٤٢"}, arg(t, `foo(٤٢);`))
+	})
+
+	t.Run("given more than one decimal point", func(t *testing.T) {
+		_, err := CompileSource(`foo(1.5.3);`)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestParseRedundantParens(t *testing.T) {
+	t.Run("a single argument in parens is the argument", func(t *testing.T) {
+		ok, err := SourceEqual(`foo((bar()));`, `foo(bar());`)
+
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+
+		prog, err := CompileSource(`foo((bar()));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]uint64{"foo": 1, "bar": 1}, prog.FuncCalls)
+	})
+
+	t.Run("nested parens are all removed", func(t *testing.T) {
+		ok, err := SourceEqual(`foo((((bar(b)))) c);`, `foo(bar(b) c);`)
+
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+	})
+
+	t.Run("a piped group is still a group", func(t *testing.T) {
+		prog, err := CompileSource(`foo((bar()|baz()));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, NodeIL_GROUP, prog.Entry.Children[0].Children[0].Kind)
+	})
+
+	t.Run("the argument is passed to the function", func(t *testing.T) {
+		v, err := eval(t, `return set((format("{}" x)));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "x", v)
+	})
+}
@@ -0,0 +1,81 @@
+package machine
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Renders a value for an error message. Strings are quoted, numbers are as short as they can be, and slices and maps
+// render each of their values.
+func renderValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return "nil"
+		}
+		return renderValue(v.Elem())
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "[]"
+		}
+
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = renderValue(v.Index(i))
+		}
+
+		return "[" + strings.Join(parts, ", ") + "]"
+	case reflect.Map:
+		parts := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			parts = append(parts, renderValue(k)+": "+renderValue(v.MapIndex(k)))
+		}
+		sort.Strings(parts) // Map keys aren't in any order.
+
+		return "{" + strings.Join(parts, ", ") + "}"
+	default:
+		if v.CanInterface() {
+			return fmt.Sprintf("%v", v.Interface())
+		}
+		return v.Type().String()
+	}
+}
+
+// Renders a node for an error message, as its kind and name.
+func renderNode(n *NodeIL) string {
+	if n.Value == nil {
+		return n.Kind.String()
+	}
+	if n.Value.Kind == NodeIL_DValue_STR && n.Kind != NodeIL_VALUE {
+		return fmt.Sprintf("%s %s", n.Kind, n.Value.Str)
+	}
+	return fmt.Sprintf("%s %s", n.Kind, renderValue(n.Value.value()))
+}
+
+// Renders a value and its type for an error message.
+func renderTyped(v reflect.Value) string {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "nil"
+	}
+	return fmt.Sprintf("%s %s", v.Type(), renderValue(v))
+}
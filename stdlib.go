@@ -2,6 +2,12 @@ package machine
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -18,6 +24,8 @@ func stdlib() *Implementation {
 
 		i.addFunc(true, "setf", "returns the passed in value", func(in float64) float64 { return in })
 
+		i.addFunc(true, "noop", "does nothing", func() {})
+
 		i.addFunc(true, "fatal", "throws a runtime error with message as the first argument", func(in string) error {
 			return &RuntimeError{
 				Code:    "Fatal",
@@ -25,18 +33,160 @@ func stdlib() *Implementation {
 			}
 		})
 
-		i.addFunc(true, "env", "returns the environment variable with the given name", func(ctx context.Context, name string) string {
-			st := Mac(ctx)
-			if st == nil {
-				return ""
+		i.addFunc(true, "env", "returns the environment variable with the given name, or the default if it isn't set", func(ctx context.Context, name string, def ...string) (string, error) {
+			if len(def) > 1 {
+				return "", &RuntimeError{
+					Code:    "ArgumentError",
+					Message: fmt.Sprintf("env takes at most 1 default, got %d", len(def)),
+				}
+			}
+
+			var v string
+			var ok bool
+			if st := Mac(ctx); st != nil {
+				v, ok = st.LookupEnv(name)
+			}
+			if !ok && len(def) == 1 {
+				return def[0], nil
 			}
-			return st.Getenv(name)
+
+			return v, nil
 		})
 
 		i.addFunc(true, "ret", "returns the groups array of return values", func(ctx context.Context) interface{} {
 			return LastReturn(ctx)
 		})
 
+		i.addFunc(true, "not", "returns the inverse of the condition", func(ctx context.Context, in interface{}) (bool, error) {
+			c, err := condition(ctx, in)
+			return !c, err
+		})
+
+		i.addFunc(true, "and", "returns true if both conditions are true", func(ctx context.Context, lhs interface{}, rhs interface{}) (bool, error) {
+			l, err := condition(ctx, lhs)
+			if err != nil {
+				return false, err
+			}
+			r, err := condition(ctx, rhs)
+			return l && r, err
+		})
+
+		i.addFunc(true, "or", "returns true if either condition is true", func(ctx context.Context, lhs interface{}, rhs interface{}) (bool, error) {
+			l, err := condition(ctx, lhs)
+			if err != nil {
+				return false, err
+			}
+			r, err := condition(ctx, rhs)
+			return l || r, err
+		})
+
+		i.addFunc(true, "format", "replaces each {} in the template with the next argument", format)
+
+		i.addFunc(true, "coalesce", "returns the first argument that isn't empty, or an empty string", coalesce)
+
+		i.addFunc(true, "contains", "returns true if the string contains the substring. Every string contains an empty substring", strings.Contains)
+
+		i.addFunc(true, "starts_with", "returns true if the string starts with the prefix. Every string starts with an empty prefix", strings.HasPrefix)
+
+		i.addFunc(true, "ends_with", "returns true if the string ends with the suffix. Every string ends with an empty suffix", strings.HasSuffix)
+
+		i.addFunc(true, "split", "returns the list of substrings between each separator. An empty separator splits every character", split)
+
+		i.addFunc(true, "join", "returns the list's values as strings joined by the separator", join)
+
+		i.addFunc(true, "deep_equal", "returns true if the values are the same type, and their elements are equal", func(a interface{}, b interface{}) bool {
+			return reflect.DeepEqual(a, b)
+		})
+
+		i.addFunc(true, "between", "returns true if the number is between low and high, including low and high", func(x float64, low float64, high float64) (bool, error) {
+			if low > high {
+				return false, &RuntimeError{
+					Code:    "ArgumentError",
+					Message: fmt.Sprintf("between low %v is greater than high %v", low, high),
+				}
+			}
+			return low <= x && x <= high, nil
+		})
+
+		i.addFunc(true, "clamp", "returns the number bounded to low and high", func(x float64, low float64, high float64) (float64, error) {
+			if low > high {
+				return 0, &RuntimeError{
+					Code:    "ArgumentError",
+					Message: fmt.Sprintf("clamp low %v is greater than high %v", low, high),
+				}
+			}
+			return math.Max(low, math.Min(x, high)), nil
+		})
+
+		i.addFunc(true, "round", "returns the nearest whole number, rounding half away from zero", func(x float64) float64 {
+			return math.Round(x)
+		})
+
+		i.addFunc(true, "floor", "returns the greatest whole number less than or equal to the number", func(x float64) float64 {
+			return math.Floor(x)
+		})
+
+		i.addFunc(true, "ceil", "returns the least whole number greater than or equal to the number", func(x float64) float64 {
+			return math.Ceil(x)
+		})
+
+		i.addFunc(true, "first", "returns the first value of a list, or of a group it's chained from", func(values ...interface{}) (interface{}, error) {
+			list, err := listOf("first", values)
+			if err != nil {
+				return nil, err
+			}
+			return list[0], nil
+		})
+
+		i.addFunc(true, "last", "returns the last value of a list, or of a group it's chained from", func(values ...interface{}) (interface{}, error) {
+			list, err := listOf("last", values)
+			if err != nil {
+				return nil, err
+			}
+			return list[len(list)-1], nil
+		})
+
+		i.addFunc(true, "assert", "throws an AssertionFailed error if the condition is false, with an optional message", func(ctx context.Context, in interface{}, msg ...string) error {
+			if len(msg) > 1 {
+				return &RuntimeError{
+					Code:    "ArgumentError",
+					Message: fmt.Sprintf("assert takes at most 1 message, got %d", len(msg)),
+				}
+			}
+
+			c, err := condition(ctx, in)
+			if err != nil {
+				return err
+			}
+			if c {
+				return nil
+			}
+
+			message := "assertion failed"
+			if len(msg) == 1 {
+				message = msg[0]
+			}
+
+			return &RuntimeError{
+				Code:    "AssertionFailed",
+				Message: message,
+			}
+		})
+
+		i.addFunc(true, "input", "returns the value at the dotted path in the machine's input", func(ctx context.Context, path string) (interface{}, error) {
+			st := Mac(ctx)
+			if st == nil {
+				return nil, inputErr(path)
+			}
+			v, ok := lookupPath(st.Input(), path)
+			if !ok {
+				return nil, inputErr(path)
+			}
+			return v, nil
+		})
+
+		i.addFunc(true, "json_get", "returns the string, number, or bool at the dotted path in the JSON document", jsonGet)
+
 		i.freeze()
 		stdlibI = i
 	})
@@ -52,3 +202,212 @@ func stdlibHasFunc(name string) bool {
 	}
 	return false
 }
+
+// Converts the value into a bool using the rules of the running machine.
+func condition(ctx context.Context, in interface{}) (bool, error) {
+	st, ok := Mac(ctx).(*machineST)
+	if !ok {
+		st = &machineST{}
+	}
+	return st.condition(reflect.ValueOf(in))
+}
+
+func format(template string, args ...interface{}) (string, error) {
+	if c := strings.Count(template, "{}"); c != len(args) {
+		return "", &RuntimeError{
+			Code:    "FormatError",
+			Message: fmt.Sprintf("template has %d placeholders but %d arguments were given", c, len(args)),
+		}
+	}
+
+	b := strings.Builder{}
+	for _, arg := range args {
+		i := strings.Index(template, "{}")
+		b.WriteString(template[:i])
+		fmt.Fprintf(&b, "%v", arg)
+		template = template[i+2:]
+	}
+	b.WriteString(template)
+
+	return b.String(), nil
+}
+
+// Splits the string into a list. An empty string is an empty list.
+func split(s string, sep string) []interface{} {
+	if s == "" {
+		return []interface{}{}
+	}
+
+	parts := strings.Split(s, sep)
+
+	list := make([]interface{}, len(parts))
+	for i, part := range parts {
+		list[i] = part
+	}
+	return list
+}
+
+// Joins each value of the list, formatted as a string.
+func join(list interface{}, sep string) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", &RuntimeError{
+			Code:    "ArgumentError",
+			Message: fmt.Sprintf("join expects a list, got %s", renderTyped(v)),
+		}
+	}
+
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%v", unwrap(v.Index(i)))
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// Returns the first value that isn't empty. nil, a zero number, false, and a string, slice, or map without any
+// elements are empty.
+func coalesce(args ...interface{}) interface{} {
+	for _, arg := range args {
+		if !isEmpty(reflect.ValueOf(arg)) {
+			return arg
+		}
+	}
+	return ""
+}
+
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// Finds the value at the dotted path. Each part of the path is a struct field, a map key, or a slice index.
+//
+// An empty path returns the whole value.
+func lookupPath(in interface{}, path string) (interface{}, bool) {
+	v := reflect.ValueOf(in)
+
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+				v = v.Elem()
+			}
+			if !v.IsValid() {
+				return nil, false
+			}
+
+			switch v.Kind() {
+			case reflect.Struct:
+				v = v.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, part) })
+			case reflect.Map:
+				if v.Type().Key().Kind() != reflect.String {
+					return nil, false
+				}
+				v = v.MapIndex(reflect.ValueOf(part).Convert(v.Type().Key()))
+			case reflect.Slice, reflect.Array:
+				i, err := strconv.Atoi(part)
+				if err != nil || i < 0 || i >= v.Len() {
+					return nil, false
+				}
+				v = v.Index(i)
+			default:
+				return nil, false
+			}
+		}
+	}
+
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+
+	return v.Interface(), true
+}
+
+// Parses the JSON document and returns the value at the dotted path. Arrays are indexed by number, and a missing key,
+// an index out of range, null, an object, or an array is an error.
+func jsonGet(doc string, path string) (interface{}, error) {
+	var in interface{}
+	if err := json.Unmarshal([]byte(doc), &in); err != nil {
+		return nil, &RuntimeError{
+			Code:    "JSONError",
+			Message: fmt.Sprintf("invalid JSON: %v", err),
+		}
+	}
+
+	v, ok := lookupPath(in, path)
+	if !ok {
+		return nil, &RuntimeError{
+			Code:    "JSONError",
+			Message: fmt.Sprintf("no JSON value at '%s'", path),
+		}
+	}
+
+	switch v.(type) {
+	case string, float64, bool:
+		return v, nil
+	default:
+		return nil, &RuntimeError{
+			Code:    "JSONError",
+			Message: fmt.Sprintf("the JSON value at '%s' is %s, not a string, number, or bool", path, jsonKind(v)),
+		}
+	}
+}
+
+// Returns the name of the JSON type for a decoded value.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	default:
+		return "null"
+	}
+}
+
+func inputErr(path string) error {
+	return &RuntimeError{
+		Code:    "InputError",
+		Message: fmt.Sprintf("no input value at '%s'", path),
+	}
+}
+
+// Returns the values as a list. A single slice is the list of its elements.
+//
+// It fails if the list is empty.
+func listOf(name string, values []interface{}) ([]interface{}, error) {
+	if len(values) == 1 {
+		if v := reflect.ValueOf(values[0]); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			values = make([]interface{}, v.Len())
+			for i := range values {
+				values[i] = unwrap(v.Index(i))
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, &RuntimeError{
+			Code:    "ArgumentError",
+			Message: fmt.Sprintf("%s of an empty list", name),
+		}
+	}
+
+	return values, nil
+}
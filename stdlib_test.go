@@ -0,0 +1,594 @@
+package machine_test
+
+import (
+	"testing"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eval(t *testing.T, src string) (interface{}, error) {
+	prog, err := CompileSource(src)
+
+	require.NoError(t, err)
+
+	return NewSync(&Implementation{}).ExecuteValue(prog)
+}
+
+func TestStdlibFormat(t *testing.T) {
+	t.Run("given an argument for each placeholder", func(t *testing.T) {
+		v, err := eval(t, `return format("cpu {} exceeds {}" 80 "90%");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu 80 exceeds 90%", v)
+	})
+
+	t.Run("given a template without placeholders", func(t *testing.T) {
+		v, err := eval(t, `return format("cpu");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu", v)
+	})
+
+	t.Run("given missing arguments", func(t *testing.T) {
+		_, err := eval(t, `return format("cpu {} exceeds {}" 80);`)
+
+		assert.EqualError(t, err, "Runtime Error: <FormatError> template has 2 placeholders but 1 arguments were given")
+	})
+
+	t.Run("given extra arguments", func(t *testing.T) {
+		_, err := eval(t, `return format("cpu {}" 80 90);`)
+
+		assert.EqualError(t, err, "Runtime Error: <FormatError> template has 1 placeholders but 2 arguments were given")
+	})
+}
+
+func TestStdlibCoalesce(t *testing.T) {
+	t.Run("given an empty string first", func(t *testing.T) {
+		v, err := eval(t, `return coalesce(env(missing) "fallback");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "fallback", v)
+	})
+
+	t.Run("given a zero number and false", func(t *testing.T) {
+		v, err := eval(t, `return coalesce(0 false 42 other);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(42), v)
+	})
+
+	t.Run("given a true bool", func(t *testing.T) {
+		v, err := eval(t, `return coalesce("" true);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, true, v)
+	})
+
+	t.Run("given only empty values", func(t *testing.T) {
+		v, err := eval(t, `return coalesce("" 0 false);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "", v)
+	})
+
+	t.Run("given no values", func(t *testing.T) {
+		v, err := eval(t, `return coalesce();`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "", v)
+	})
+}
+
+func TestStdlibInput(t *testing.T) {
+	type metric struct {
+		Name  string
+		Value float64
+	}
+
+	type event struct {
+		Metric *metric
+		Tags   []string
+	}
+
+	run := func(t *testing.T, input interface{}, src string) (interface{}, error) {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+
+		m := NewSync(&Implementation{})
+		m.SetInput(input)
+
+		return m.ExecuteValue(prog)
+	}
+
+	ev := event{Metric: &metric{Name: "cpu", Value: 0.92}, Tags: []string{"prod"}}
+
+	t.Run("given a nested struct", func(t *testing.T) {
+		v, err := run(t, ev, `return input("metric.value");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 0.92, v)
+	})
+
+	t.Run("given a slice index", func(t *testing.T) {
+		v, err := run(t, ev, `return input("tags.0");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "prod", v)
+	})
+
+	t.Run("given a map", func(t *testing.T) {
+		v, err := run(t, map[string]interface{}{"metric": map[string]interface{}{"name": "mem"}}, `return set(input("metric.name"));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "mem", v)
+	})
+
+	t.Run("given a missing path", func(t *testing.T) {
+		_, err := run(t, ev, `return input("metric.missing");`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<InputError> no input value at 'metric.missing'")
+	})
+
+	t.Run("given no input", func(t *testing.T) {
+		_, err := run(t, nil, `return input(metric);`)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestStdlibAssert(t *testing.T) {
+	t.Run("given a true condition", func(t *testing.T) {
+		_, err := eval(t, `assert(true);`)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("given a false condition", func(t *testing.T) {
+		_, err := eval(t, `assert(not(true));`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <AssertionFailed> assertion failed", err.Error())
+	})
+
+	t.Run("given a false condition with a message", func(t *testing.T) {
+		_, err := eval(t, `assert(false "cpu must be set");`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <AssertionFailed> cpu must be set", err.Error())
+	})
+
+	t.Run("given a condition that isn't a bool", func(t *testing.T) {
+		_, err := eval(t, `assert(yes);`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <TypeError> expected a bool condition, got string", err.Error())
+	})
+
+	t.Run("given more than one message", func(t *testing.T) {
+		_, err := eval(t, `assert(false a b);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentError>")
+	})
+
+	t.Run("statements after a failed assertion aren't run", func(t *testing.T) {
+		v, err := eval(t, "assert(false);\nreturn set(after);")
+
+		assert.Error(t, err)
+		assert.Nil(t, v)
+	})
+}
+
+func TestStdlibBetween(t *testing.T) {
+	t.Run("given a number in the range", func(t *testing.T) {
+		v, err := eval(t, `return between(5 1 10);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, true, v)
+	})
+
+	t.Run("given a number on the boundaries", func(t *testing.T) {
+		v, err := eval(t, `return and(between(1 1 10) between(10 1 10));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, true, v)
+	})
+
+	t.Run("given a number out of the range", func(t *testing.T) {
+		v, err := eval(t, `return or(between(-0.5 0 10) between(10.5 0 10));`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, false, v)
+	})
+
+	t.Run("given a value that isn't a number", func(t *testing.T) {
+		_, err := eval(t, `return between(five 1 10);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentTypeError> arg 1 of 'between': expected float64, got string")
+	})
+
+	t.Run("given low greater than high", func(t *testing.T) {
+		_, err := eval(t, `return between(5 10 1);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentError>")
+	})
+}
+
+func TestStdlibEnv(t *testing.T) {
+	m := NewSync(&Implementation{})
+	m.Setenv("region", "us-east-1")
+	m.Setenv("empty", "")
+
+	t.Run("given an unset variable", func(t *testing.T) {
+		v, err := execValue(t, m, `return env(zone);`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "", v)
+	})
+
+	t.Run("given an unset variable and a default", func(t *testing.T) {
+		v, err := execValue(t, m, `return env(zone us-west-2a);`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "us-west-2a", v)
+	})
+
+	t.Run("given an empty variable and a default", func(t *testing.T) {
+		v, err := execValue(t, m, `return env(empty fallback);`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "", v)
+	})
+
+	t.Run("given a set variable and a default", func(t *testing.T) {
+		v, err := execValue(t, m, `return env(region us-west-2);`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "us-east-1", v)
+	})
+
+	t.Run("given more than one default", func(t *testing.T) {
+		_, err := execValue(t, m, `return env(zone a b);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "env takes at most 1 default, got 2")
+	})
+}
+
+func TestStdlibClamp(t *testing.T) {
+	t.Run("given a number below the range", func(t *testing.T) {
+		v, err := eval(t, `return clamp(-2 1 10);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(1), v)
+	})
+
+	t.Run("given a number in the range", func(t *testing.T) {
+		v, err := eval(t, `return clamp(f5.5 1 10);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 5.5, v)
+	})
+
+	t.Run("given a number above the range", func(t *testing.T) {
+		v, err := eval(t, `return clamp(12 1 10);`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(10), v)
+	})
+
+	t.Run("given a value that isn't a number", func(t *testing.T) {
+		_, err := eval(t, `return clamp(five 1 10);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentTypeError> arg 1 of 'clamp': expected float64, got string")
+	})
+
+	t.Run("given low greater than high", func(t *testing.T) {
+		_, err := eval(t, `return clamp(5 10 1);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentError> clamp low 10 is greater than high 1")
+	})
+}
+
+func TestStdlibFirstLast(t *testing.T) {
+	i := &Implementation{}
+	i.Func("a", func() string { return "ra" })
+	i.Func("b", func() float64 { return 2 })
+	i.Func("c", func() bool { return true })
+	i.Func("list", func() []string { return []string{"x", "y", "z"} })
+	i.Func("nothing", func() {})
+
+	t.Run("given a group", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return (a()|b()|c()).first();`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "ra", v)
+
+		v, err = execValue(t, NewSync(i), `return (a()|b()|c()).last();`)
+
+		require.NoError(t, err)
+		assert.Equal(t, true, v)
+	})
+
+	t.Run("given a slice", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return first(list());`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "x", v)
+
+		v, err = execValue(t, NewSync(i), `return last(list());`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "z", v)
+	})
+
+	t.Run("given values", func(t *testing.T) {
+		v, err := execValue(t, NewSync(i), `return last(a b c);`)
+
+		require.NoError(t, err)
+		assert.Equal(t, "c", v)
+	})
+
+	t.Run("given an empty group", func(t *testing.T) {
+		_, err := execValue(t, NewSync(i), `return (nothing()|nothing()).first();`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <ArgumentError> first of an empty list", err.Error())
+
+		_, err = execValue(t, NewSync(i), `return (nothing()|nothing()).last();`)
+
+		require.Error(t, err)
+		assert.Equal(t, "Runtime Error: <ArgumentError> last of an empty list", err.Error())
+	})
+}
+
+func TestStdlibRounding(t *testing.T) {
+	cases := []struct {
+		x     string
+		round float64
+		floor float64
+		ceil  float64
+	}{
+		{x: "f2.3", round: 2, floor: 2, ceil: 3},
+		{x: "f2.5", round: 3, floor: 2, ceil: 3},
+		{x: "f2.7", round: 3, floor: 2, ceil: 3},
+		{x: "-2.3", round: -2, floor: -3, ceil: -2},
+		{x: "-2.5", round: -3, floor: -3, ceil: -2},
+		{x: "-2.7", round: -3, floor: -3, ceil: -2},
+		{x: "4", round: 4, floor: 4, ceil: 4},
+	}
+
+	for _, tc := range cases {
+		t.Run("given "+tc.x, func(t *testing.T) {
+			for name, expected := range map[string]float64{"round": tc.round, "floor": tc.floor, "ceil": tc.ceil} {
+				v, err := eval(t, "return "+name+"("+tc.x+");")
+
+				require.NoError(t, err)
+
+				assert.Equal(t, expected, v, name)
+			}
+		})
+	}
+
+	t.Run("given a value that isn't a number", func(t *testing.T) {
+		_, err := eval(t, `return round(five);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentTypeError> arg 1 of 'round': expected float64, got string")
+	})
+}
+
+func TestStdlibStringPredicates(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected bool
+	}{
+		{src: `contains("service.cpu.p99" cpu)`, expected: true},
+		{src: `contains("service.cpu.p99" mem)`, expected: false},
+		{src: `contains("service.cpu.p99" "")`, expected: true},
+		{src: `starts_with("service.cpu.p99" service)`, expected: true},
+		{src: `starts_with("service.cpu.p99" p99)`, expected: false},
+		{src: `starts_with("service.cpu.p99" "")`, expected: true},
+		{src: `ends_with("service.cpu.p99" p99)`, expected: true},
+		{src: `ends_with("service.cpu.p99" service)`, expected: false},
+		{src: `ends_with("service.cpu.p99" "")`, expected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run("given "+tc.src, func(t *testing.T) {
+			v, err := eval(t, "return "+tc.src+";")
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, v)
+		})
+	}
+
+	t.Run("given a value that isn't a string", func(t *testing.T) {
+		_, err := eval(t, `return contains("abc" 42);`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "<ArgumentTypeError> arg 2 of 'contains': expected string, got float64")
+	})
+}
+
+func TestStdlibJSONGet(t *testing.T) {
+	doc := `{"data": {"name": "cpu", "items": [{"value": 90.5, "ok": false}, {"value": 12}], "empty": null}}`
+
+	get := func(path string) (interface{}, error) {
+		prog, err := CompileSource(`return json_get(env(payload) "` + path + `");`)
+
+		require.NoError(t, err)
+
+		m := NewSync(&Implementation{})
+		m.Setenv("payload", doc)
+
+		return m.ExecuteValue(prog)
+	}
+
+	cases := map[string]interface{}{
+		"data.name":          "cpu",
+		"data.items.0.value": 90.5,
+		"data.items.0.ok":    false,
+		"data.items.1.value": float64(12),
+	}
+
+	for path, expected := range cases {
+		t.Run("given the path "+path, func(t *testing.T) {
+			v, err := get(path)
+
+			require.NoError(t, err)
+
+			assert.Equal(t, expected, v)
+		})
+	}
+
+	errs := map[string]string{
+		"data.missing":       "<JSONError> no JSON value at 'data.missing'",
+		"data.items.2.value": "<JSONError> no JSON value at 'data.items.2.value'",
+		"data.items.first":   "<JSONError> no JSON value at 'data.items.first'",
+		"data.name.length":   "<JSONError> no JSON value at 'data.name.length'",
+		"data.items":         "<JSONError> the JSON value at 'data.items' is an array, not a string, number, or bool",
+		"data":               "<JSONError> the JSON value at 'data' is an object, not a string, number, or bool",
+		"data.empty":         "<JSONError> the JSON value at 'data.empty' is null, not a string, number, or bool",
+	}
+
+	for path, expected := range errs {
+		t.Run("given the bad path "+path, func(t *testing.T) {
+			_, err := get(path)
+
+			require.Error(t, err)
+
+			assert.Contains(t, err.Error(), expected)
+		})
+	}
+
+	t.Run("given invalid JSON", func(t *testing.T) {
+		_, err := eval(t, `return json_get("{nope" data);`)
+
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "<JSONError> invalid JSON")
+	})
+}
+
+func TestStdlibSplitJoin(t *testing.T) {
+	t.Run("given a separated string", func(t *testing.T) {
+		v, err := eval(t, `return split("web,api,worker" ",");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{"web", "api", "worker"}, v)
+	})
+
+	t.Run("given an empty separator", func(t *testing.T) {
+		v, err := eval(t, `return split("héy" "");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{"h", "é", "y"}, v)
+	})
+
+	t.Run("given an empty string", func(t *testing.T) {
+		v, err := eval(t, `return split("" ",");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{}, v)
+	})
+
+	t.Run("round-tripping split and join", func(t *testing.T) {
+		for _, src := range []string{"web,api,worker", "web", ",,", "a,,b"} {
+			v, err := eval(t, `return join(split("`+src+`" ",") ",");`)
+
+			require.NoError(t, err)
+
+			assert.Equal(t, src, v)
+		}
+	})
+
+	t.Run("given a list with values that aren't strings", func(t *testing.T) {
+		i := &Implementation{}
+		i.Func("values", func() []interface{} { return []interface{}{"cpu", float64(90), true} })
+
+		prog, err := CompileSource(`return join(values() " ");`)
+
+		require.NoError(t, err)
+
+		v, err := NewSync(i).ExecuteValue(prog)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, "cpu 90 true", v)
+	})
+
+	t.Run("given a value that isn't a list", func(t *testing.T) {
+		_, err := eval(t, `return join(web ",");`)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `<ArgumentError> join expects a list, got string "web"`)
+	})
+}
+
+func TestStdlibDeepEqual(t *testing.T) {
+	input := map[string]interface{}{
+		"a": map[string]interface{}{"tags": []interface{}{"web", "api"}, "limits": map[string]interface{}{"cpu": float64(90)}},
+		"b": map[string]interface{}{"tags": []interface{}{"web", "api"}, "limits": map[string]interface{}{"cpu": float64(90)}},
+		"c": map[string]interface{}{"tags": []interface{}{"api", "web"}, "limits": map[string]interface{}{"cpu": float64(90)}},
+		"d": map[string]interface{}{"tags": []interface{}{"web", "api"}, "limits": map[string]interface{}{"cpu": 90}},
+	}
+
+	cases := []struct {
+		src      string
+		expected bool
+	}{
+		{src: `deep_equal(input(a) input(b))`, expected: true},
+		{src: `deep_equal(input(a) input(c))`, expected: false},
+		{src: `deep_equal(input(a) input(d))`, expected: false},
+		{src: `deep_equal(input("a.tags") split("web,api" ","))`, expected: true},
+		{src: `deep_equal(input("a.limits.cpu") 90)`, expected: true},
+		{src: `deep_equal(90 "90")`, expected: false},
+		{src: `deep_equal(true "true")`, expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run("given "+tc.src, func(t *testing.T) {
+			prog, err := CompileSource("return " + tc.src + ";")
+
+			require.NoError(t, err)
+
+			m := NewSync(&Implementation{})
+			m.SetInput(input)
+
+			v, err := m.ExecuteValue(prog)
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, v)
+		})
+	}
+}
@@ -22,27 +22,83 @@ func (e *SourceError) Error() string {
 	return fmt.Sprintf("Source error (Ln %d, Col %d): %s", e.Line, e.Column, e.Message)
 }
 
+// The runes that have a meaning to the tokenizer, so they can't be the statement terminator.
+const reservedRunes = "()|.=$\"?:{}+ \t\\"
+
 func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 	scanner := comp.scanner()
 
 	var line uint32
+	var braces int // The depth of the `{` that haven't been closed
+
+	term := comp.terminator()
+	if strings.ContainsRune(reservedRunes, term) || term == utf8.RuneError {
+		fail(&SourceError{
+			Line:    1,
+			Column:  1,
+			Message: fmt.Sprintf("%q can't be used as the statement terminator", term),
+		})
+	}
+
+	if max := comp.Options.MaxSourceBytes; max > 0 && len(comp.Source) > max {
+		fail(&SourceError{
+			Line:    1,
+			Column:  1,
+			Message: fmt.Sprintf("Source is %d bytes, longer than the maximum of %d bytes", len(comp.Source), max),
+		})
+	}
+
+	push := func(t *TokenIL) {
+		if max := comp.Options.MaxTokens; max > 0 && len(comp.Tokens) >= max {
+			fail(&SourceError{
+				Line:    t.Line,
+				Column:  t.Column,
+				Message: fmt.Sprintf("Source has more than the maximum of %d tokens", max),
+			})
+		}
+		comp.Tokens = append(comp.Tokens, t)
+	}
+
+	var continued bool // The previous line ended with a `\`, so this line is part of the same statement
+	var lineStart int  // The index of the first token in the statement's line
+	var ternaries int  // The `?` tokens on the line that haven't been matched with a `:`
+	var parens int     // The depth of the `(` on the line that haven't been closed
+	var labeled bool   // The line has a match case's `:`
 
 	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			fail(err)
+		}
+
 		line++
 
-		if len(comp.Tokens) > 0 {
+		if !continued && len(comp.Tokens) > 0 {
 			last := comp.Tokens[len(comp.Tokens)-1]
 
-			if last.Kind != TokenIL_END {
+			// A line that opens or closes a match's braces doesn't need a `;`
+			if last.Kind != TokenIL_END && last.Kind != TokenIL_LBRACE && last.Kind != TokenIL_RBRACE {
 				fail(&SourceError{
 					Line:    last.Line,
 					Column:  last.Column,
-					Message: fmt.Sprintf("Line must end with a `;`"),
+					Message: fmt.Sprintf("Line must end with a `%c`", term),
 				})
 			}
 		}
 
-		runes := bufio.NewScanner(bytes.NewReader(scanner.Bytes()))
+		if !continued {
+			lineStart = len(comp.Tokens)
+			ternaries, parens, labeled = 0, 0, false
+		}
+
+		// A `\` at the end of the line joins the next line to the statement, as if it were a space.
+		src := scanner.Bytes()
+		trimmed := bytes.TrimRight(src, " \t")
+		continued = bytes.HasSuffix(trimmed, []byte{'\\'})
+		if continued {
+			src = trimmed[:len(trimmed)-1]
+		}
+
+		runes := bufio.NewScanner(bytes.NewReader(src))
 		runes.Split(bufio.ScanRunes)
 
 		type value struct {
@@ -53,7 +109,7 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 		}
 
 		appendToken := func(k TokenIL_Kind, col uint32) {
-			comp.Tokens = append(comp.Tokens, &TokenIL{
+			push(&TokenIL{
 				Kind:   k,
 				Line:   line,
 				Column: col,
@@ -61,9 +117,29 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 		}
 
 		appendValue := func(v *value) {
-			comp.Tokens = append(comp.Tokens, &TokenIL{
+			str := strings.TrimSpace(v.buf.String())
+			if str == "" {
+				return // Whitespace isn't a value
+			}
+
+			// A `+` on its own is the operator. Inside a value, like a time's offset, it's part of the value.
+			if str == "+" {
+				appendToken(TokenIL_PLUS, v.startC)
+				return
+			}
+
+			push(&TokenIL{
 				Kind:   TokenIL_VALUE,
-				Value:  strings.TrimSpace(v.buf.String()),
+				Value:  str,
+				Line:   v.startL,
+				Column: v.startC,
+			})
+		}
+
+		appendString := func(v *value) {
+			push(&TokenIL{
+				Kind:   TokenIL_STRING,
+				Value:  v.buf.String(),
 				Line:   v.startL,
 				Column: v.startC,
 			})
@@ -71,6 +147,8 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 
 		var col uint32
 		var val *value
+		var str *value // The quoted string currently being read
+		var escaped bool
 
 		for runes.Scan() {
 			col++
@@ -88,8 +166,28 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 				})
 			}
 
+			// Everything inside of quotes is part of the string. Only `\"` and `\\` are escapes.
+			if str != nil {
+				switch {
+				case escaped:
+					if r != '"' && r != '\\' {
+						str.buf.WriteRune('\\')
+					}
+					str.buf.WriteRune(r)
+					escaped = false
+				case r == '\\':
+					escaped = true
+				case r == '"':
+					appendString(str)
+					str = nil
+				default:
+					str.buf.WriteRune(r)
+				}
+				continue
+			}
+
 			switch r {
-			case ';':
+			case term:
 				breaking = true
 				if col != 1 { // The first character of the line is a comment. Skip...
 					kind = TokenIL_END
@@ -97,10 +195,20 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 			case '(':
 				completing = true
 				kind = TokenIL_OPEN
+				parens++
 			case ')':
 				completing = true
 				kind = TokenIL_CLOSE
-			case ' ':
+				parens--
+			case '{':
+				completing = true
+				kind = TokenIL_LBRACE
+				braces++
+			case '}':
+				completing = true
+				kind = TokenIL_RBRACE
+				braces--
+			case ' ', '\t': // Whitespace only separates values
 				completing = true
 			case '|':
 				completing = true
@@ -114,6 +222,28 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 			case '$':
 				completing = true
 				kind = TokenIL_VAR
+			case '"':
+				completing = true
+			case '?':
+				completing = true
+				kind = TokenIL_QUESTION
+				ternaries++
+			case ':':
+				// A `:` is only a token when it closes a `?`, or follows a match case, so values like times can still
+				// contain them.
+				if ternaries > 0 {
+					completing = true
+					kind = TokenIL_COLON
+					ternaries--
+					break
+				}
+				if braces > 0 && parens == 0 && !labeled {
+					completing = true
+					kind = TokenIL_COLON
+					labeled = true
+					break
+				}
+				fallthrough
 			default:
 				if val == nil {
 					val = &value{
@@ -135,13 +265,50 @@ func tokenize(ctx context.Context, comp *compiler, fail failable.FailFunc) {
 				appendToken(kind, col)
 			}
 
+			if r == '"' {
+				str = &value{
+					buf:    strings.Builder{},
+					startL: line,
+					startC: col,
+				}
+			}
+
 			if breaking {
+				continued = false // A `\` after the end of the statement is part of a comment.
 				break
 			}
 		}
 
+		if str != nil {
+			fail(&SourceError{
+				Line:    str.startL,
+				Column:  str.startC,
+				Message: "String is missing a closing `\"`",
+			})
+		}
+
 		if val != nil {
 			appendValue(val)
 		}
+
+		// A line that isn't empty ends the statement when the terminator is a newline.
+		if term == '\n' && !continued && len(comp.Tokens) > lineStart {
+			if last := comp.Tokens[len(comp.Tokens)-1]; last.Kind != TokenIL_LBRACE {
+				appendToken(TokenIL_END, col+1)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		msg := err.Error()
+		if err == bufio.ErrTooLong {
+			msg = fmt.Sprintf("Line is longer than the maximum of %d bytes", maxLineLength)
+		}
+
+		fail(&SourceError{
+			Line:    line + 1,
+			Column:  1,
+			Message: msg,
+		})
 	}
 }
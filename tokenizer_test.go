@@ -1 +1,265 @@
 package machine_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/maddiesch/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeStrings(t *testing.T) {
+	t.Run("given a quoted string", func(t *testing.T) {
+		prog, err := CompileSource(`foo("cpu on ; (us-east-1)" "say \"hi\" \\ \n");`)
+
+		require.NoError(t, err)
+
+		expected := &NodeIL{
+			Kind: NodeIL_ROOT,
+			Children: []*NodeIL{
+				{
+					Kind:  NodeIL_FUNC,
+					Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "foo"},
+					Children: []*NodeIL{
+						{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: "cpu on ; (us-east-1)"}},
+						{Kind: NodeIL_VALUE, Value: &NodeIL_DValue{Kind: NodeIL_DValue_STR, Str: `say "hi" \ \n`}},
+					},
+				},
+			},
+		}
+
+		assert.True(t, NodeCompare(prog.Entry, expected))
+
+		t.Run("the generated source compiles to the same program", func(t *testing.T) {
+			p2, err := CompileSource(prog.Source)
+
+			require.NoError(t, err)
+
+			assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+		})
+	})
+
+	t.Run("a quoted bool is a string", func(t *testing.T) {
+		prog, err := CompileSource(`foo("true");`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, NodeIL_DValue_STR, prog.Entry.Children[0].Children[0].Value.Kind)
+	})
+
+	t.Run("given an unterminated string", func(t *testing.T) {
+		_, err := CompileSource(`foo("bar);`)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Source error (Ln 1, Col 5): String is missing a closing `\"`", err.Error())
+	})
+}
+
+func TestTokenizeWhitespace(t *testing.T) {
+	args := func(t *testing.T, src string) []*NodeIL {
+		prog, err := CompileSource(src)
+
+		require.NoError(t, err)
+		require.Len(t, prog.Entry.Children, 1)
+
+		return prog.Entry.Children[0].Children
+	}
+
+	t.Run("given only spaces between the parens", func(t *testing.T) {
+		assert.Empty(t, args(t, "foo(  );"))
+	})
+
+	t.Run("given only tabs between the parens", func(t *testing.T) {
+		assert.Empty(t, args(t, "foo(\t\t);"))
+	})
+
+	t.Run("given more than one space between arguments", func(t *testing.T) {
+		expected, err := CompileSource("foo(a b);")
+
+		require.NoError(t, err)
+
+		prog, err := CompileSource("foo(a  b);")
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(expected.Entry, prog.Entry))
+	})
+
+	t.Run("given leading and trailing spaces inside a call", func(t *testing.T) {
+		values := args(t, "foo( a b );")
+
+		require.Len(t, values, 2)
+		assert.Equal(t, "a", values[0].Value.Str)
+		assert.Equal(t, "b", values[1].Value.Str)
+	})
+
+	t.Run("given tabs between arguments", func(t *testing.T) {
+		values := args(t, "foo(\ta \t b\t);")
+
+		require.Len(t, values, 2)
+		assert.Equal(t, "a", values[0].Value.Str)
+		assert.Equal(t, "b", values[1].Value.Str)
+	})
+}
+
+func TestTokenizeLongLines(t *testing.T) {
+	t.Run("given a line longer than the default scanner buffer", func(t *testing.T) {
+		long := strings.Repeat("a", 100*1024)
+
+		prog, err := CompileSource(`foo("` + long + `" bar);`)
+
+		require.NoError(t, err)
+
+		require.Len(t, prog.Entry.Children[0].Children, 2)
+		assert.Equal(t, long, prog.Entry.Children[0].Children[0].Value.Str)
+	})
+
+	t.Run("given a line longer than the maximum", func(t *testing.T) {
+		src := "foo();\n" + strings.Repeat("a", 16*1024*1024+1)
+
+		_, err := CompileSource(src)
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Source error (Ln 2, Col 1): Line is longer than the maximum of 16777216 bytes", err.Error())
+	})
+}
+
+func TestTokenizeTerminator(t *testing.T) {
+	expected, err := CompileSource("foo(bar);\nconst a = set(x);\nbaz($a);")
+
+	require.NoError(t, err)
+
+	t.Run("given a newline terminator", func(t *testing.T) {
+		opts := CompileOptions{Terminator: '\n'}
+
+		prog, err := CompileSourceWithOptions("foo(bar)\n\nconst a = set(x)\nbaz($a)\n", opts)
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(expected.Entry, prog.Entry))
+		assert.Equal(t, "foo(bar)\nconst a = set(x)\nbaz($a)\n", prog.Source)
+
+		t.Run("the generated source compiles to the same program", func(t *testing.T) {
+			p2, err := CompileSourceWithOptions(prog.Source, opts)
+
+			require.NoError(t, err)
+
+			assert.True(t, NodeCompare(prog.Entry, p2.Entry))
+		})
+	})
+
+	t.Run("given a newline terminator and a match", func(t *testing.T) {
+		prog, err := CompileSourceWithOptions("match (set(a)) {\n\"a\": foo(bar)\n}\n", CompileOptions{Terminator: '\n'})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, NodeIL_MATCH, prog.Entry.Children[0].Kind)
+	})
+
+	t.Run("given another terminator", func(t *testing.T) {
+		prog, err := CompileSourceWithOptions("foo(bar)!\n!a comment\nconst a = set(x)!\nbaz($a; b)!", CompileOptions{Terminator: '!'})
+
+		require.NoError(t, err)
+
+		require.Len(t, prog.Entry.Children, 3)
+		assert.Equal(t, "a;", prog.Entry.Children[2].Children[0].Value.Str)
+
+		_, err = CompileSourceWithOptions("foo(bar);\nbaz(bar)!", CompileOptions{Terminator: '!'})
+
+		require.Error(t, err)
+		assert.Equal(t, "Source error (Ln 1, Col 9): Line must end with a `!`", err.Error())
+	})
+
+	t.Run("given a reserved terminator", func(t *testing.T) {
+		_, err := CompileSourceWithOptions("foo(bar).", CompileOptions{Terminator: '.'})
+
+		require.Error(t, err)
+		assert.Equal(t, "Source error (Ln 1, Col 1): '.' can't be used as the statement terminator", err.Error())
+	})
+
+	t.Run("given the add operator as the terminator", func(t *testing.T) {
+		_, err := CompileSourceWithOptions("return setf(1 + 2)+", CompileOptions{Terminator: '+'})
+
+		require.Error(t, err)
+		assert.Equal(t, "Source error (Ln 1, Col 1): '+' can't be used as the statement terminator", err.Error())
+	})
+
+	t.Run("given a tab as the terminator", func(t *testing.T) {
+		_, err := CompileSourceWithOptions("set(a\tb)\t", CompileOptions{Terminator: '\t'})
+
+		require.Error(t, err)
+		assert.Equal(t, "Source error (Ln 1, Col 1): '\\t' can't be used as the statement terminator", err.Error())
+	})
+}
+
+func TestTokenizeLimits(t *testing.T) {
+	src := "foo(bar);\nbaz(qux);"
+
+	t.Run("given source longer than the maximum", func(t *testing.T) {
+		_, err := CompileSourceWithOptions(src, CompileOptions{MaxSourceBytes: 10})
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Source error (Ln 1, Col 1): Source is 19 bytes, longer than the maximum of 10 bytes", err.Error())
+	})
+
+	t.Run("given more tokens than the maximum", func(t *testing.T) {
+		_, err := CompileSourceWithOptions(src, CompileOptions{MaxTokens: 6})
+
+		require.Error(t, err)
+
+		assert.Equal(t, "Source error (Ln 2, Col 4): Source has more than the maximum of 6 tokens", err.Error())
+	})
+
+	t.Run("given source within the limits", func(t *testing.T) {
+		_, err := CompileSourceWithOptions(src, CompileOptions{MaxSourceBytes: 19, MaxTokens: 10})
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestTokenizeLineContinuation(t *testing.T) {
+	t.Run("given a continued call", func(t *testing.T) {
+		prog, err := CompileSource("alert(response-time \\\n  GTE \\\n  600);\npage();")
+
+		require.NoError(t, err)
+
+		expected, err := CompileSource("alert(response-time GTE 600);\npage();")
+
+		require.NoError(t, err)
+
+		assert.True(t, NodeCompare(expected.Entry, prog.Entry))
+		assert.Equal(t, expected.Source, prog.Source)
+	})
+
+	t.Run("the tokens keep their line and column", func(t *testing.T) {
+		_, err := CompileSource("alert(response-time \\\n  GTE |);")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "(Ln 2, Col 7,")
+	})
+
+	t.Run("given a continued line missing a terminator", func(t *testing.T) {
+		_, err := CompileSource("alert(response-time \\\n  GTE 600)\npage();")
+
+		require.Error(t, err)
+		assert.Equal(t, "Source error (Ln 2, Col 10): Line must end with a `;`", err.Error())
+	})
+
+	t.Run("given a comment ending in a backslash", func(t *testing.T) {
+		prog, err := CompileSource("; a comment \\\npage();")
+
+		require.NoError(t, err)
+		assert.Len(t, prog.Entry.Children, 1)
+	})
+
+	t.Run("given a newline terminator", func(t *testing.T) {
+		prog, err := CompileSourceWithOptions("alert(response-time \\\n  GTE 600)\npage()", CompileOptions{Terminator: '\n'})
+
+		require.NoError(t, err)
+		assert.Len(t, prog.Entry.Children, 2)
+	})
+}